@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fire/arguments"
 	"fire/firestorm"
+	"fire/firestorm/diagnostic"
 	"fire/project"
 	"fmt"
 )
@@ -11,6 +12,9 @@ import (
 type Build struct{}
 
 func (Build) PopulateParser(parser *arguments.Parser) {
+	parser.Allow("force", "Rebuild everything instead of using the build cache")
+	firestorm.AllowOptimization(parser)
+	parser.Allow("profile", "Build profile debug or release")
 }
 
 func (Build) Execute(parser *arguments.Parser) error {
@@ -31,9 +35,44 @@ func (Build) Execute(parser *arguments.Parser) error {
 		target = &newTarget
 	}
 
-	firestorm.Compile(proj.Compiler.Input, proj.Compiler.Output, *target, proj.Compiler.Includes)
+	optimization, err := buildOptimization(parser, proj.Compiler)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	cache := firestorm.NewCache(firestorm.CacheDirectory, parser.Has("force"))
+	options := firestorm.Options{Target: *target, Includes: proj.Compiler.Includes, Optimization: optimization, Cache: cache}
+	diagnostics, err := firestorm.Compile([]string{proj.Compiler.Input}, proj.Compiler.Output, options)
+	diagnostic.Print(diagnostics)
+	if cache.UpToDate {
+		fmt.Println("Up to date")
+	}
+
+	return err
+}
+
+// buildOptimization selects the optimization level from --opt, --profile or the project file, in this order.
+func buildOptimization(parser *arguments.Parser, compiler *project.Compiler) (string, error) {
+	level, err := firestorm.ConsumeOptimization(parser)
+	if err != nil || level != "" {
+		return level, err
+	}
+
+	if parser.Has("profile") {
+		profile, err := parser.Consume("profile", nil)
+		if err != nil {
+			return "", err
+		}
+		level, ok := firestorm.Profiles[*profile]
+		if !ok {
+			return "", errors.New("Unknown profile " + *profile + ", expected debug or release")
+		}
+		return level, nil
+	}
+	if compiler.Optimization != nil {
+		return *compiler.Optimization, firestorm.CheckOptimization(*compiler.Optimization)
+	}
+	return "", nil
 }
 
 func (Build) Description() string {
@@ -0,0 +1,26 @@
+package commands
+
+import (
+	"fire/arguments"
+	"fire/firestorm"
+	"fmt"
+	"os"
+)
+
+type Clean struct{}
+
+func (Clean) PopulateParser(parser *arguments.Parser) {
+}
+
+func (Clean) Execute(parser *arguments.Parser) error {
+	err := os.RemoveAll(firestorm.CacheDirectory)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Removed build cache.")
+	return nil
+}
+
+func (Clean) Description() string {
+	return "Remove the build cache"
+}
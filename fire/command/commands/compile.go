@@ -3,16 +3,18 @@ package commands
 import (
 	"fire/arguments"
 	"fire/firestorm"
+	"fire/firestorm/diagnostic"
 	"strings"
 )
 
 type Compile struct{}
 
 func (Compile) PopulateParser(parser *arguments.Parser) {
-	parser.Allow("input", "Input file")
-	parser.Allow("outpu", "Output file")
+	parser.Allow("input", "Input file, can be given multiple times")
+	parser.Allow("output", "Output file")
 	parser.Allow("target", "Compilation target")
 	parser.Allow("include", "Add file to include path")
+	firestorm.AllowOptimization(parser)
 }
 
 func (Compile) Execute(parser *arguments.Parser) error {
@@ -20,6 +22,14 @@ func (Compile) Execute(parser *arguments.Parser) error {
 	if err != nil {
 		return err
 	}
+	inputs := []string{*input}
+	for parser.Has("input") {
+		input, err := parser.Consume("input", nil)
+		if err != nil {
+			return err
+		}
+		inputs = append(inputs, *input)
+	}
 
 	defaultOutput := "a." + firestorm.DetectExtension()
 	output, err := parser.Consume("output", &defaultOutput)
@@ -33,6 +43,11 @@ func (Compile) Execute(parser *arguments.Parser) error {
 		return err
 	}
 
+	optimization, err := firestorm.ConsumeOptimization(parser)
+	if err != nil {
+		return err
+	}
+
 	includes := []string{}
 	for parser.Has("include") {
 		include, err := parser.Consume("include", nil)
@@ -46,9 +61,10 @@ func (Compile) Execute(parser *arguments.Parser) error {
 		}
 	}
 
-	firestorm.Compile(*input, *output, *target, includes)
+	diagnostics, err := firestorm.Compile(inputs, *output, firestorm.Options{Target: *target, Includes: includes, Optimization: optimization})
+	diagnostic.Print(diagnostics)
 
-	return nil
+	return err
 }
 
 func (Compile) Description() string {
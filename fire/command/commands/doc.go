@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"fire/arguments"
+	"fire/firestorm"
+	"fire/firestorm/diagnostic"
+	"fire/firestorm/doc"
+	"fire/project"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Doc struct{}
+
+func (Doc) PopulateParser(parser *arguments.Parser) {
+	parser.Allow("input", "File or directory to document, defaults to the current directory")
+	parser.Allow("output", "Output directory, defaults to docs")
+	parser.Allow("target", "Target used to compute offset layouts")
+	parser.Allow("include", "Add file to include path")
+}
+
+// sourceFiles returns the .fl files of input, directories are searched recursively except for hidden ones.
+func sourceFiles(input string) ([]string, error) {
+	files := []string{}
+	err := filepath.Walk(input, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != input && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".fl") {
+			files = append(files, filepath.ToSlash(path))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+func (Doc) Execute(parser *arguments.Parser) error {
+	title := "Documentation"
+	includes := []string{}
+	if proj, err := project.Load(); err == nil {
+		title = proj.Name
+		if proj.Compiler != nil {
+			includes = append(includes, proj.Compiler.Includes...)
+		}
+	}
+
+	inputs := []string{}
+	for parser.Has("input") {
+		input, err := parser.Consume("input", nil)
+		if err != nil {
+			return err
+		}
+		inputs = append(inputs, *input)
+	}
+	if len(inputs) == 0 {
+		inputs = append(inputs, ".")
+	}
+
+	defaultOutput := "docs"
+	output, err := parser.Consume("output", &defaultOutput)
+	if err != nil {
+		return err
+	}
+
+	defaultTarget := firestorm.DetectTarget()
+	target, err := parser.Consume("target", &defaultTarget)
+	if err != nil {
+		return err
+	}
+
+	for parser.Has("include") {
+		include, err := parser.Consume("include", nil)
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(*include, "/") {
+			includes = append(includes, *include+"/")
+		} else {
+			includes = append(includes, *include)
+		}
+	}
+
+	files := []*doc.File{}
+	for _, input := range inputs {
+		paths, err := sourceFiles(input)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			file, diagnostics, err := doc.Collect(path, includes, *target)
+			diagnostic.Print(diagnostics)
+			if err != nil {
+				return err
+			}
+			files = append(files, file)
+		}
+	}
+
+	html, err := doc.HTML(title, files)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(*output, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(*output, "index.md"), []byte(doc.Markdown(title, files)), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(*output, "index.html"), []byte(html), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Documented " + strconv.Itoa(len(files)) + " file(s) in " + *output)
+	return nil
+}
+
+func (Doc) Description() string {
+	return "Generate Markdown and HTML documentation"
+}
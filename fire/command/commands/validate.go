@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fire/arguments"
 	"fire/firestorm"
+	"fire/firestorm/diagnostic"
 	"log/slog"
 	"os"
 	"os/exec"
@@ -16,12 +17,26 @@ import (
 type Validate struct{}
 
 type Expected struct {
-	Arguments  []string `json:"arguments"`
-	Output     []string `json:"output"`
-	ShouldFail bool     `json:"should_fail"`
+	Arguments     []string `json:"arguments"`
+	Output        []string `json:"output"`
+	ShouldFail    bool     `json:"should_fail"`
+	CompileErrors []string `json:"compile_errors"`
+	// Targets restricts the test to targets starting with one of the prefixes, it runs on all targets if empty
+	Targets []string `json:"targets"`
+}
+
+// supports returns true if the test runs on target.
+func (e Expected) supports(target string) bool {
+	for _, prefix := range e.Targets {
+		if strings.HasPrefix(target, prefix) {
+			return true
+		}
+	}
+	return len(e.Targets) == 0
 }
 
 func (Validate) PopulateParser(parser *arguments.Parser) {
+	firestorm.AllowOptimization(parser)
 }
 
 func run(command string, arguments []string) (*string, error) {
@@ -49,12 +64,21 @@ func run(command string, arguments []string) (*string, error) {
 func (Validate) Execute(parser *arguments.Parser) error {
 	passed := 0
 	notPassed := 0
+	skipped := 0
 	extension := firestorm.DetectExtension()
 	target := firestorm.DetectTarget()
+	optimization, err := firestorm.ConsumeOptimization(parser)
+	if err != nil {
+		return err
+	}
 
-	err := filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+	err = filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
 		if err == nil {
 			path = strings.ReplaceAll(path, "\\", "/")
+			// modules in imports are only compiled as part of the tests importing them
+			if info.IsDir() && path == "imports" {
+				return filepath.SkipDir
+			}
 			if !strings.HasSuffix(path, ".fl") {
 				return nil
 			}
@@ -73,7 +97,13 @@ func (Validate) Execute(parser *arguments.Parser) error {
 				slog.Error(err.Error(), "path", path)
 				return nil
 			}
+			if !expected.supports(target) {
+				slog.Debug("TEST SKIPPED", "path", path, "target", target)
+				skipped++
+				return nil
+			}
 
+			// a crash of the compiler fails the test instead of the whole validation
 			defer func() {
 				if r := recover(); r != nil {
 					slog.Error("TEST NOT PASSED", "path", path, "error", r)
@@ -81,7 +111,22 @@ func (Validate) Execute(parser *arguments.Parser) error {
 					notPassed++
 				}
 			}()
-			firestorm.Compile(path, path+"."+extension, target, []string{"../libraries/stdlib/"})
+			diagnostics, err := firestorm.Compile([]string{path}, path+"."+extension, firestorm.Options{Target: target, Includes: []string{"../libraries/stdlib/"}, Optimization: optimization})
+			if len(expected.CompileErrors) > 0 {
+				if checkCompileErrors(path, diagnostics, err, expected.CompileErrors) {
+					slog.Debug("TEST PASSED", "path", path)
+					passed++
+				} else {
+					notPassed++
+				}
+				return nil
+			}
+			if err != nil {
+				diagnostic.Print(diagnostics)
+				slog.Error("TEST NOT PASSED", "path", path, "error", err)
+				notPassed++
+				return nil
+			}
 
 			output, err := run("./"+path+"."+extension, expected.Arguments)
 			if err != nil {
@@ -118,13 +163,36 @@ func (Validate) Execute(parser *arguments.Parser) error {
 		}
 		return nil
 	})
-	slog.Info("Validation done", "passed", passed, "notPassed", notPassed)
+	slog.Info("Validation done", "passed", passed, "notPassed", notPassed, "skipped", skipped)
 	if notPassed > 0 {
 		return errors.New("not all tests passed")
 	}
 	return err
 }
 
+func checkCompileErrors(path string, diagnostics []diagnostic.Diagnostic, err error, expected []string) bool {
+	if err == nil {
+		slog.Error("TEST NOT PASSED", "path", path, "error", "expected compilation to fail")
+		return false
+	}
+
+	for _, message := range expected {
+		found := false
+		for _, d := range diagnostics {
+			if d.Severity == diagnostic.Error && strings.Contains(d.Message, message) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diagnostic.Print(diagnostics)
+			slog.Error("TEST NOT PASSED", "path", path, "error", "missing compile error", "expected", message)
+			return false
+		}
+	}
+	return true
+}
+
 func (Validate) Description() string {
 	return "Run the tests"
 }
@@ -0,0 +1,138 @@
+package firestorm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CacheDirectory is where fire build keeps its cache, relative to the project.
+var CacheDirectory = ".fire/build"
+
+// Cache stores the results of earlier compilations. A build whose sources and options didn't change is skipped,
+// otherwise only the units whose code changed are compiled by clang again.
+type Cache struct {
+	Dir string
+	// Force ignores the stored results, the new ones are still stored
+	Force bool
+	// UpToDate is set by Compile if the output was already built from the same sources
+	UpToDate bool
+}
+
+func NewCache(dir string, force bool) *Cache {
+	return &Cache{Dir: dir, Force: force}
+}
+
+// manifest describes how an output was built.
+type manifest struct {
+	// hash of the inputs, output and options
+	Key string `json:"key"`
+	// hash of every file read while preprocessing, "" for include candidates that didn't exist
+	Files  map[string]string `json:"files"`
+	Output string            `json:"output"`
+}
+
+// contentHash hashes all parts, the result is usable as file name.
+func contentHash(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashFile returns the hash of the content of path or "" if it can't be read.
+func hashFile(path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return contentHash(string(content))
+}
+
+// compilerHash identifies the running compiler, results of another build of fire are never reused.
+// It is "" if the executable can't be read, which disables the cache.
+var compilerHash = sync.OnceValue(func() string {
+	path, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	return hashFile(path)
+})
+
+func (c *Cache) manifestPath(output string) string {
+	return filepath.Join(c.Dir, contentHash(output)[:16]+".json")
+}
+
+// upToDate returns true if output was built with the same key and none of the files it was built from changed.
+// The versions of used packages are covered by the $use directives in the files.
+func (c *Cache) upToDate(output string, key string) bool {
+	if c == nil || c.Force || compilerHash() == "" {
+		return false
+	}
+
+	data, err := os.ReadFile(c.manifestPath(output))
+	if err != nil {
+		return false
+	}
+	var m manifest
+	if json.Unmarshal(data, &m) != nil || m.Key != key {
+		return false
+	}
+	for path, hash := range m.Files {
+		if hashFile(path) != hash {
+			return false
+		}
+	}
+	return m.Output != "" && hashFile(output) == m.Output
+}
+
+// invalidate removes the manifest of output, so a failed build isn't mistaken for an earlier successful one.
+func (c *Cache) invalidate(output string) {
+	if c == nil {
+		return
+	}
+	os.Remove(c.manifestPath(output))
+}
+
+// store writes the manifest of output after it was built successfully.
+func (c *Cache) store(output string, key string, files map[string]string) error {
+	if c == nil {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(manifest{Key: key, Files: files, Output: hashFile(output)}, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(c.Dir, fs.ModePerm)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.manifestPath(output), data, fs.ModePerm)
+}
+
+// object returns the path of the cached object file of a module compiled with flags and whether it already
+// exists. The path is "" without a cache.
+func (c *Cache) object(module string, flags string) (string, bool, error) {
+	if c == nil || compilerHash() == "" {
+		return "", false, nil
+	}
+
+	dir := filepath.Join(c.Dir, "objects")
+	err := os.MkdirAll(dir, fs.ModePerm)
+	if err != nil {
+		return "", false, err
+	}
+	path := filepath.Join(dir, contentHash(module, flags, compilerHash())+".o")
+	if c.Force {
+		return path, false, nil
+	}
+	_, err = os.Stat(path)
+	return path, err == nil, nil
+}
@@ -0,0 +1,1079 @@
+package checker
+
+import (
+	"fire/firestorm/constexpr"
+	"fire/firestorm/diagnostic"
+	"fire/firestorm/parser"
+	"fire/firestorm/utils"
+	"strconv"
+	"strings"
+)
+
+// The checker runs between the parser and the backend. It resolves every variable and function
+// reference and makes sure values are only used where their datatype fits.
+//
+// Conversion rules:
+//   - integer types (int, i64, i32, i16, i8, u64, u32, u16, u8, chr, ptr) convert implicitly into each other,
+//     narrowing a non constant value results in a warning
+//   - i64 and int are the same type
+//   - str and chr[] are the same type
+//   - ptr is the untyped address and converts implicitly from and to every array and typed pointer type
+//   - typed pointers (int*, chr**) convert implicitly from and to arrays of the same element type,
+//     arithmetic on them is scaled by the element size
+//   - constant integers convert implicitly to array types (0 as null pointer)
+//   - struct values are references and behave like arrays in the rules above
+//   - function pointers (fn(int) -> int) convert implicitly from and to ptr and from constant integers,
+//     between each other only if the signatures match
+//   - integers convert implicitly to floats, f64 to f32 results in a warning for non constant values,
+//     floats to integers need an explicit cast
+//   - arithmetic mixing integers and floats is done in the float type
+//   - integer arithmetic is done in int unless an operand is u64 or ptr, which makes it unsigned
+//   - everything else (e.g. int to str or int[] to chr[]) needs an explicit "as" cast
+//
+// Modules loaded by import have their declarations prefixed with the module name. Inside a module unqualified
+// names refer to its own declarations before the ones of the main file and included files. alias.name refers
+// to a declaration of an imported module, which has to be exported with the global attribute.
+
+type symbol struct {
+	datatype parser.UnnamedDatatype
+	pos      int
+	final    bool
+	// exported is set for global variables visible to modules importing the module declaring them
+	exported bool
+}
+
+type function struct {
+	function parser.Function
+	pos      int
+}
+
+type Checker struct {
+	global    *parser.Node
+	reporter  *diagnostic.Reporter
+	globals   map[string]symbol
+	functions map[string]function
+	structs   map[string]parser.Offset
+	current   *parser.Function
+	// local variables of the enclosing code blocks, innermost last
+	scopes []map[string]symbol
+	// labels of the enclosing loops, innermost last
+	loops []string
+	// module of the checked declaration, empty for the main file
+	module string
+	// imported module of every alias by importing module
+	imports map[string]map[string]string
+}
+
+var invalid = parser.UnnamedDatatype{Type: parser.INVALID}
+var integer = parser.UnnamedDatatype{Type: parser.INT}
+var str = parser.UnnamedDatatype{Type: parser.STR}
+var f64 = parser.UnnamedDatatype{Type: parser.FLOAT_64}
+var character = parser.UnnamedDatatype{Type: parser.CHR}
+
+func NewChecker(global *parser.Node, reporter *diagnostic.Reporter) *Checker {
+	return &Checker{
+		global:    global,
+		reporter:  reporter,
+		globals:   make(map[string]symbol),
+		functions: make(map[string]function),
+		structs:   make(map[string]parser.Offset),
+		scopes:    []map[string]symbol{},
+		current:   nil,
+		imports:   make(map[string]map[string]string),
+	}
+}
+
+func isInvalid(d parser.UnnamedDatatype) bool {
+	return d.Type == parser.INVALID
+}
+
+// indirect returns true for arrays and typed pointers, which refer to values of their type.
+func indirect(d parser.UnnamedDatatype) bool {
+	return d.IsArray || d.Pointer > 0
+}
+
+func isVoid(d parser.UnnamedDatatype) bool {
+	return !indirect(d) && d.Type == parser.VOID
+}
+
+func isInteger(d parser.UnnamedDatatype) bool {
+	if indirect(d) {
+		return false
+	}
+	switch d.Type {
+	case parser.INT, parser.INT_64, parser.INT_32, parser.INT_16, parser.INT_8, parser.CHR, parser.PTR,
+		parser.UINT_64, parser.UINT_32, parser.UINT_16, parser.UINT_8:
+		return true
+	default:
+		return false
+	}
+}
+
+func isFloat(d parser.UnnamedDatatype) bool {
+	return !indirect(d) && (d.Type == parser.FLOAT_32 || d.Type == parser.FLOAT_64)
+}
+
+func isNumber(d parser.UnnamedDatatype) bool {
+	return isFloat(d) || (isInteger(d) && !isUntypedPointer(d))
+}
+
+func isPointer(d parser.UnnamedDatatype) bool {
+	return indirect(d) || d.Type == parser.STR || d.Type == parser.STRUCT
+}
+
+func isTypedPointer(d parser.UnnamedDatatype) bool {
+	return !d.IsArray && d.Pointer > 0
+}
+
+func isStruct(d parser.UnnamedDatatype) bool {
+	return !indirect(d) && d.Type == parser.STRUCT
+}
+
+func isFunctionPointer(d parser.UnnamedDatatype) bool {
+	return !indirect(d) && d.Type == parser.FUNCTION_POINTER
+}
+
+func isUntypedPointer(d parser.UnnamedDatatype) bool {
+	return !indirect(d) && d.Type == parser.PTR
+}
+
+// normalize maps str to chr[] and i64 to int since both share the same representation.
+func normalize(d parser.UnnamedDatatype) parser.UnnamedDatatype {
+	if !indirect(d) && d.Type == parser.STR {
+		return parser.UnnamedDatatype{Type: parser.CHR, IsArray: true}
+	}
+	if d.Type == parser.INT_64 {
+		return parser.UnnamedDatatype{Type: parser.INT, IsArray: d.IsArray, Pointer: d.Pointer}
+	}
+	return d
+}
+
+// samePointer returns true if the pointers a and b refer to the same datatype. A typed pointer refers to the
+// same values as an array of its element type.
+func samePointer(a parser.UnnamedDatatype, b parser.UnnamedDatatype) bool {
+	a = normalize(a)
+	b = normalize(b)
+	if a.Equals(b) {
+		return true
+	}
+	if (isTypedPointer(a) && b.IsArray) || (a.IsArray && isTypedPointer(b)) {
+		return element(a).Equals(element(b))
+	}
+	return false
+}
+
+// element returns the datatype of the values an array or typed pointer refers to.
+func element(d parser.UnnamedDatatype) parser.UnnamedDatatype {
+	if d.IsArray {
+		d.IsArray = false
+		d.Length = 0
+		return d
+	}
+	d.Pointer--
+	return d
+}
+
+func integerSize(d parser.UnnamedDatatype) int {
+	switch d.Type {
+	case parser.INT_32, parser.UINT_32:
+		return 4
+	case parser.INT_16, parser.UINT_16:
+		return 2
+	case parser.CHR, parser.INT_8, parser.UINT_8:
+		return 1
+	case parser.FLOAT_32:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// arithmeticResult returns the datatype of an arithmetic operation on two numbers.
+func arithmeticResult(a parser.UnnamedDatatype, b parser.UnnamedDatatype) parser.UnnamedDatatype {
+	if isFloat(a) && isFloat(b) {
+		if integerSize(a) > integerSize(b) {
+			return a
+		}
+		return b
+	}
+	if isFloat(a) {
+		return a
+	}
+	if isFloat(b) {
+		return b
+	}
+	if isUntypedPointer(a) || isUntypedPointer(b) {
+		return parser.UnnamedDatatype{Type: parser.PTR}
+	}
+	if a.Type == parser.UINT_64 || b.Type == parser.UINT_64 {
+		return parser.UnnamedDatatype{Type: parser.UINT_64}
+	}
+	return integer
+}
+
+func isConstant(node *parser.Node) bool {
+	_, err := constexpr.Fold(node)
+	return err == nil
+}
+
+func (c *Checker) error(pos int, message string, notes ...diagnostic.Diagnostic) {
+	c.reporter.Error(pos, message, notes...)
+}
+
+// assignable reports an error if the value of node (with datatype from) can't be stored into to.
+func (c *Checker) assignable(node *parser.Node, from parser.UnnamedDatatype, to parser.UnnamedDatatype, context string) {
+	if isInvalid(from) || isInvalid(to) {
+		return
+	}
+
+	if isVoid(from) {
+		c.error(node.Pos, "Void value used "+context)
+		return
+	}
+
+	if normalize(from).Equals(normalize(to)) {
+		return
+	}
+
+	if isInteger(from) && isInteger(to) {
+		if integerSize(to) < integerSize(from) && !isConstant(node) {
+			c.reporter.Warning(node.Pos, "Implicit conversion from "+from.String()+" to "+to.String()+" may truncate the value "+context)
+		}
+		return
+	}
+
+	if isFloat(to) && isNumber(from) {
+		if isFloat(from) && integerSize(to) < integerSize(from) && !isConstant(node) {
+			c.reporter.Warning(node.Pos, "Implicit conversion from "+from.String()+" to "+to.String()+" may lose precision "+context)
+		}
+		return
+	}
+
+	if (isPointer(from) && isUntypedPointer(to)) || (isUntypedPointer(from) && isPointer(to)) {
+		return
+	}
+
+	if isPointer(from) && isPointer(to) && samePointer(from, to) {
+		return
+	}
+
+	if (isFunctionPointer(from) && isUntypedPointer(to)) || (isUntypedPointer(from) && isFunctionPointer(to)) {
+		return
+	}
+
+	if isInteger(from) && (isPointer(to) || isFunctionPointer(to)) && isConstant(node) {
+		return
+	}
+
+	c.error(node.Pos, "Cannot use "+from.String()+" as "+to.String()+" "+context)
+}
+
+// moduleOf returns the module of a declaration from its name, declarations of the main file have no prefix.
+func moduleOf(name string) string {
+	if module, _, ok := strings.Cut(name, "."); ok {
+		return module
+	}
+	return ""
+}
+
+func (c *Checker) isLocal(name string) bool {
+	for _, scope := range c.scopes {
+		if _, ok := scope[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Checker) isGlobal(name string) bool {
+	_, variable := c.globals[name]
+	_, function := c.functions[name]
+	return variable || function
+}
+
+// qualify returns the name the backend knows the variable or function name of the current module by.
+func (c *Checker) qualify(name string, pos int) string {
+	alias, member, ok := strings.Cut(name, ".")
+	if !ok {
+		if c.module != "" && !c.isLocal(name) && c.isGlobal(c.module+"."+name) {
+			return c.module + "." + name
+		}
+		return name
+	}
+
+	module, ok := c.imports[c.module][alias]
+	if !ok {
+		return name
+	}
+	qualified := member
+	if module != "" {
+		qualified = module + "." + member
+	}
+	if f, ok := c.functions[qualified]; ok && utils.IndexOf(f.function.Attributes, parser.Global) == -1 {
+		c.error(pos, "Function "+member+" of module "+alias+" is private, declare it as function(global) to export it", c.reporter.Note(f.pos, member+" declared here"))
+	} else if v, ok := c.globals[qualified]; ok && !v.exported {
+		c.error(pos, "Variable "+member+" of module "+alias+" is private, declare it as global to export it", c.reporter.Note(v.pos, member+" declared here"))
+	} else if !c.isGlobal(qualified) {
+		c.error(pos, "Module "+alias+" has no variable or function "+member)
+	}
+	return qualified
+}
+
+// lookupVariable returns the local or global variable name or nil without reporting an error.
+func (c *Checker) lookupVariable(name string) *symbol {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if v, ok := c.scopes[i][name]; ok {
+			return &v
+		}
+	}
+	if v, ok := c.globals[name]; ok {
+		return &v
+	}
+	return nil
+}
+
+func (c *Checker) findVariable(name string, pos int) *symbol {
+	v := c.lookupVariable(name)
+	if v == nil {
+		c.error(pos, "Variable "+name+" not declared")
+	}
+	return v
+}
+
+func (c *Checker) findFunction(name string, pos int) *function {
+	if f, ok := c.functions[name]; ok {
+		return &f
+	}
+	c.error(pos, "Function "+name+" not declared")
+	return nil
+}
+
+// checkField returns the datatype of the field looked up by node.
+func (c *Checker) checkField(node *parser.Node) parser.UnnamedDatatype {
+	d := c.checkScalar(node.A)
+	if isInvalid(d) {
+		return invalid
+	}
+	if !isStruct(d) {
+		c.error(node.Pos, "Cannot access field "+node.Value.(string)+" of "+d.String())
+		return invalid
+	}
+
+	for _, entry := range c.structs[d.Name].Entries {
+		if entry.Name == node.Value.(string) {
+			return entry.UnnamedDatatype
+		}
+	}
+	c.error(node.Pos, "No field "+node.Value.(string)+" in struct "+d.Name)
+	return invalid
+}
+
+func (c *Checker) checkFunctionCall(node *parser.Node) parser.UnnamedDatatype {
+	fc := node.Value.(parser.FunctionCall)
+	if fc.Callee == nil {
+		fc.Name = c.qualify(fc.Name, node.Pos)
+		node.Value = fc
+	}
+
+	arguments := []parser.UnnamedDatatype{}
+	for _, argument := range fc.Arguments {
+		arguments = append(arguments, c.checkExpression(argument))
+	}
+
+	if fc.Callee != nil {
+		return c.checkIndirectCall(node, c.checkScalar(fc.Callee), arguments)
+	}
+	// variables holding function pointers shadow functions of the same name
+	if v := c.lookupVariable(fc.Name); v != nil {
+		return c.checkIndirectCall(node, v.datatype, arguments)
+	}
+
+	f := c.findFunction(fc.Name, node.Pos)
+	if f == nil {
+		return invalid
+	}
+
+	if f.function.Variadic && len(fc.Arguments) < len(f.function.Arguments) {
+		c.error(node.Pos, "Function "+fc.Name+" expects at least "+strconv.Itoa(len(f.function.Arguments))+" argument(s) but got "+strconv.Itoa(len(fc.Arguments)), c.reporter.Note(f.pos, fc.Name+" declared here"))
+		return f.function.ReturnDatatype
+	}
+	if !f.function.Variadic && len(fc.Arguments) != len(f.function.Arguments) {
+		c.error(node.Pos, "Function "+fc.Name+" expects "+strconv.Itoa(len(f.function.Arguments))+" argument(s) but got "+strconv.Itoa(len(fc.Arguments)), c.reporter.Note(f.pos, fc.Name+" declared here"))
+		return f.function.ReturnDatatype
+	}
+
+	for i := range f.function.Arguments {
+		c.assignable(fc.Arguments[i], arguments[i], f.function.Arguments[i].UnnamedDatatype, "for argument "+f.function.Arguments[i].Name+" of "+fc.Name)
+	}
+	// additional arguments of variadic functions are passed with the C default promotions
+	for i := len(f.function.Arguments); i < len(fc.Arguments); i++ {
+		if isVoid(arguments[i]) {
+			c.error(fc.Arguments[i].Pos, "Void value used as argument of "+fc.Name)
+		}
+	}
+
+	return f.function.ReturnDatatype
+}
+
+func (c *Checker) checkIndirectCall(node *parser.Node, callee parser.UnnamedDatatype, arguments []parser.UnnamedDatatype) parser.UnnamedDatatype {
+	fc := node.Value.(parser.FunctionCall)
+	if isInvalid(callee) {
+		return invalid
+	}
+	if !isFunctionPointer(callee) {
+		c.error(node.Pos, "Cannot call "+fc.Name+" of type "+callee.String())
+		return invalid
+	}
+
+	signature := callee.Signature
+	if len(fc.Arguments) != len(signature.Arguments) {
+		c.error(node.Pos, "Function pointer "+fc.Name+" expects "+strconv.Itoa(len(signature.Arguments))+" argument(s) but got "+strconv.Itoa(len(fc.Arguments)))
+		return signature.ReturnDatatype
+	}
+
+	for i := range fc.Arguments {
+		c.assignable(fc.Arguments[i], arguments[i], signature.Arguments[i], "for argument "+strconv.Itoa(i+1)+" of "+fc.Name)
+	}
+	return signature.ReturnDatatype
+}
+
+// checkScalar makes sure a value can be used as condition or operand.
+func (c *Checker) checkScalar(node *parser.Node) parser.UnnamedDatatype {
+	d := c.checkExpression(node)
+	if isVoid(d) {
+		c.error(node.Pos, "Void value used as operand")
+		return invalid
+	}
+	return d
+}
+
+func (c *Checker) checkIntegerOperands(node *parser.Node, a parser.UnnamedDatatype, b parser.UnnamedDatatype) parser.UnnamedDatatype {
+	if isInvalid(a) || isInvalid(b) {
+		return invalid
+	}
+
+	if !isInteger(a) || !isInteger(b) {
+		c.error(node.Pos, "Invalid operands "+a.String()+" and "+b.String()+", expected integers")
+		return invalid
+	}
+
+	return arithmeticResult(a, b)
+}
+
+func (c *Checker) checkFloatOperands(node *parser.Node, a parser.UnnamedDatatype, b parser.UnnamedDatatype) parser.UnnamedDatatype {
+	if isInvalid(a) || isInvalid(b) {
+		return invalid
+	}
+
+	if !isNumber(a) || !isNumber(b) {
+		c.error(node.Pos, "Invalid operands "+a.String()+" and "+b.String()+", expected numbers")
+		return invalid
+	}
+
+	// constants adapt to the float type of the other operand
+	if isFloat(a) && isConstant(node.B) {
+		return a
+	}
+	if isFloat(b) && isConstant(node.A) {
+		return b
+	}
+	return arithmeticResult(a, b)
+}
+
+// checkExpression returns the datatype of node and stores it in the node for the backend.
+func (c *Checker) checkExpression(node *parser.Node) parser.UnnamedDatatype {
+	node.Datatype = c.expressionDatatype(node)
+	return node.Datatype
+}
+
+func (c *Checker) expressionDatatype(node *parser.Node) parser.UnnamedDatatype {
+	switch node.Type {
+	case parser.NUMBER:
+		return integer
+	case parser.CHARACTER:
+		return character
+	case parser.FLOAT:
+		return f64
+	case parser.STRING:
+		return str
+	case parser.VARIABLE_LOOKUP:
+		name := c.qualify(node.Value.(string), node.Pos)
+		node.Value = name
+		if v := c.lookupVariable(name); v != nil {
+			// fixed size arrays decay to array pointers
+			d := v.datatype
+			d.Length = 0
+			return d
+		}
+		// the name of a function is its address
+		if f, ok := c.functions[name]; ok {
+			if f.function.Variadic {
+				c.error(node.Pos, "Cannot take the address of variadic function "+name)
+				return invalid
+			}
+			return f.function.Signature()
+		}
+		c.findVariable(name, node.Pos)
+		return invalid
+	case parser.VARIABLE_LOOKUP_ARRAY:
+		node.Value = c.qualify(node.Value.(string), node.Pos)
+		index := c.checkScalar(node.A)
+		if !isInvalid(index) && !isInteger(index) {
+			c.error(node.A.Pos, "Index has to be an integer but was "+index.String())
+		}
+
+		v := c.findVariable(node.Value.(string), node.Pos)
+		if v == nil {
+			return invalid
+		}
+		d := normalize(v.datatype)
+		if indirect(d) {
+			c.checkBounds(node.A, v, node.Value.(string))
+			return element(d)
+		}
+		if isInteger(d) {
+			// bit index
+			return integer
+		}
+		c.error(node.Pos, "Cannot index "+v.datatype.String())
+		return invalid
+	case parser.DEREFERENCE:
+		d := normalize(c.checkScalar(node.A))
+		if isInvalid(d) {
+			return invalid
+		}
+		if !indirect(d) {
+			c.error(node.Pos, "Cannot dereference "+d.String()+", expected a typed pointer like int*")
+			return invalid
+		}
+		return element(d)
+	case parser.ADDRESS_OF:
+		return c.checkAddressOf(node)
+	case parser.FUNCTION_CALL:
+		return c.checkFunctionCall(node)
+	case parser.FIELD_LOOKUP:
+		return c.checkField(node)
+	case parser.INLINE_ASSEMBLY:
+		for _, operand := range node.Value.(parser.InlineAssembly).Operands {
+			d := c.checkScalar(operand)
+			if isFloat(d) {
+				c.error(operand.Pos, "Assembly operands have to be integers or pointers but got "+d.String())
+			}
+		}
+		return integer
+	case parser.SIZEOF:
+		if isVoid(node.Value.(parser.UnnamedDatatype)) {
+			c.error(node.Pos, "Cannot take size of void")
+		}
+		return integer
+	case parser.CAST:
+		from := c.checkScalar(node.A)
+		to := node.Value.(parser.UnnamedDatatype)
+		if isVoid(to) {
+			c.error(node.Pos, "Cannot cast to void")
+			return invalid
+		}
+		valid := isInteger(from) || isPointer(from) || isFunctionPointer(from)
+		if isFloat(from) || isFloat(to) {
+			// floats only convert from and to numbers
+			valid = isNumber(from) && isNumber(to)
+		}
+		if !isInvalid(from) && !valid {
+			c.error(node.Pos, "Cannot cast "+from.String()+" to "+to.String())
+		}
+		return to
+	case parser.COMPARE:
+		a := c.checkScalar(node.A)
+		b := c.checkScalar(node.B)
+		if isPointer(a) && isPointer(b) && !samePointer(a, b) {
+			c.error(node.Pos, "Cannot compare "+a.String()+" with "+b.String())
+		} else if (isFloat(a) || isFloat(b)) && !isInvalid(a) && !isInvalid(b) && (!isNumber(a) || !isNumber(b)) {
+			c.error(node.Pos, "Cannot compare "+a.String()+" with "+b.String())
+		}
+		return integer
+	case parser.NOT:
+		c.checkScalar(node.A)
+		return integer
+	case parser.LOGICAL_AND, parser.LOGICAL_OR:
+		c.checkScalar(node.A)
+		c.checkScalar(node.B)
+		return integer
+	case parser.PLUS, parser.MINUS:
+		d := c.checkScalar(node.A)
+		if !isInvalid(d) && !isInteger(d) && !isFloat(d) {
+			c.error(node.Pos, "Invalid operand "+d.String()+", expected number")
+			return invalid
+		}
+		if isFloat(d) {
+			return d
+		}
+		return arithmeticResult(d, d)
+	case parser.BIT_NOT:
+		d := c.checkScalar(node.A)
+		if !isInvalid(d) && !isInteger(d) {
+			c.error(node.Pos, "Invalid operand "+d.String()+", expected integer")
+			return invalid
+		}
+		return arithmeticResult(d, d)
+	case parser.ADD, parser.SUBTRACT:
+		a := c.checkScalar(node.A)
+		b := c.checkScalar(node.B)
+		if isInvalid(a) || isInvalid(b) {
+			return invalid
+		}
+
+		// pointer arithmetic
+		if isPointer(a) && isInteger(b) {
+			return a
+		}
+		if isInteger(a) && isPointer(b) && node.Type == parser.ADD {
+			return b
+		}
+		if isPointer(a) && isPointer(b) && node.Type == parser.SUBTRACT && samePointer(a, b) {
+			return integer
+		}
+
+		if isFloat(a) || isFloat(b) {
+			return c.checkFloatOperands(node, a, b)
+		}
+		if !isInteger(a) || !isInteger(b) {
+			c.error(node.Pos, "Invalid operands "+a.String()+" and "+b.String())
+			return invalid
+		}
+		return arithmeticResult(a, b)
+	case parser.MULTIPLY, parser.DIVIDE, parser.MODULO:
+		a := c.checkScalar(node.A)
+		b := c.checkScalar(node.B)
+		if isFloat(a) || isFloat(b) {
+			return c.checkFloatOperands(node, a, b)
+		}
+		return c.checkIntegerOperands(node, a, b)
+	case parser.AND, parser.OR, parser.XOR, parser.SHIFT_LEFT, parser.SHIFT_RIGHT:
+		return c.checkIntegerOperands(node, c.checkScalar(node.A), c.checkScalar(node.B))
+	default:
+		panic("Unknown " + strconv.Itoa(int(node.Type)))
+	}
+}
+
+func (c *Checker) declareLocal(datatype parser.NamedDatatype, pos int) {
+	scope := c.scopes[len(c.scopes)-1]
+	if previous, ok := scope[datatype.Name]; ok {
+		c.error(pos, "Duplicate definition of "+datatype.Name, c.reporter.Note(previous.pos, "previous definition of "+datatype.Name))
+		return
+	}
+	scope[datatype.Name] = symbol{datatype: datatype.UnnamedDatatype, pos: pos, final: false}
+}
+
+func (c *Checker) pushScope() {
+	c.scopes = append(c.scopes, make(map[string]symbol))
+}
+
+func (c *Checker) popScope() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+// checkScope checks body in a new scope, variables declared in it aren't visible afterwards.
+func (c *Checker) checkScope(body []*parser.Node) {
+	c.pushScope()
+	c.checkCodeBlock(body)
+	c.popScope()
+}
+
+// checkInitializer checks the initial value of a variable, array literals are checked element by element.
+func (c *Checker) checkInitializer(value *parser.Node, datatype parser.UnnamedDatatype, name string) {
+	if value.Type != parser.ARRAY_LITERAL {
+		c.assignable(value, c.checkExpression(value), datatype, "to initialize "+name)
+		return
+	}
+
+	d := normalize(datatype)
+	if !d.IsArray {
+		c.error(value.Pos, "Cannot initialize "+datatype.String()+" "+name+" with an array literal")
+		return
+	}
+	value.Datatype = datatype
+
+	element := d
+	element.IsArray = false
+	for i, e := range value.Value.([]*parser.Node) {
+		c.assignable(e, c.checkExpression(e), element, "as element "+strconv.Itoa(i)+" of "+name)
+	}
+}
+
+// checkAddressOf returns the typed pointer to the variable of an &name expression.
+func (c *Checker) checkAddressOf(node *parser.Node) parser.UnnamedDatatype {
+	name := c.qualify(node.Value.(string), node.Pos)
+	node.Value = name
+	v := c.findVariable(name, node.Pos)
+	if v == nil {
+		return invalid
+	}
+	if v.final {
+		c.error(node.Pos, "Cannot take the address of final variable "+name, c.reporter.Note(v.pos, name+" declared here"))
+		return invalid
+	}
+	if v.datatype.IsArray {
+		c.error(node.Pos, "Cannot take the address of array "+name+", it is already a pointer", c.reporter.Note(v.pos, name+" declared here"))
+		return invalid
+	}
+	d := v.datatype
+	d.Pointer++
+	return d
+}
+
+// checkBounds reports constant indexes outside of a fixed size array.
+func (c *Checker) checkBounds(index *parser.Node, v *symbol, name string) {
+	if v.datatype.Length == 0 {
+		return
+	}
+	i, err := constexpr.Evaluate(index)
+	if err == nil && (i < 0 || i >= v.datatype.Length) {
+		c.error(index.Pos, "Index "+strconv.Itoa(i)+" is out of bounds for "+name+" with "+strconv.Itoa(v.datatype.Length)+" elements",
+			c.reporter.Note(v.pos, name+" declared here"))
+	}
+}
+
+// checkFixedArray reports fixed size arrays outside of local variables, what describes the declaration.
+func (c *Checker) checkFixedArray(datatype parser.UnnamedDatatype, pos int, what string) {
+	if datatype.Length > 0 {
+		c.error(pos, what+" can't be a fixed size array, only local variables can")
+	}
+}
+
+// checkModifyTarget is checkAssignTarget for statements replacing the whole variable.
+func (c *Checker) checkModifyTarget(name string, pos int) *symbol {
+	v := c.checkAssignTarget(name, pos)
+	if v != nil && v.datatype.Length > 0 {
+		c.error(pos, "Cannot assign to fixed size array "+name, c.reporter.Note(v.pos, name+" declared here"))
+	}
+	return v
+}
+
+// checkFixedInitializer checks that a fixed size array is initialized with an array literal which fits into it.
+func (c *Checker) checkFixedInitializer(value *parser.Node, datatype parser.NamedDatatype) {
+	if value.Type != parser.ARRAY_LITERAL {
+		c.error(value.Pos, "Fixed size array "+datatype.Name+" can only be initialized with an array literal")
+		return
+	}
+	if elements := len(value.Value.([]*parser.Node)); elements > datatype.Length {
+		c.error(value.Pos, "Too many elements for "+datatype.String()+" "+datatype.Name+", expected at most "+strconv.Itoa(datatype.Length)+" but got "+strconv.Itoa(elements))
+	}
+}
+
+func (c *Checker) checkAssignTarget(name string, pos int) *symbol {
+	v := c.findVariable(name, pos)
+	if v != nil && v.final {
+		c.error(pos, "Cannot assign to final variable "+name, c.reporter.Note(v.pos, name+" declared here"))
+	}
+	return v
+}
+
+func (c *Checker) checkCodeBlock(body []*parser.Node) {
+	for _, node := range body {
+		switch node.Type {
+		case parser.VARIABLE_DECLARATION:
+			datatype := node.Value.(parser.NamedDatatype)
+			if isVoid(datatype.UnnamedDatatype) {
+				c.error(node.Pos, "Variable "+datatype.Name+" can't be void")
+			}
+			if node.A != nil {
+				c.checkInitializer(node.A, datatype.UnnamedDatatype, datatype.Name)
+				if datatype.Length > 0 {
+					c.checkFixedInitializer(node.A, datatype)
+				}
+			}
+			c.declareLocal(datatype, node.Pos)
+		case parser.VARIABLE_ASSIGN:
+			node.Value = c.qualify(node.Value.(string), node.Pos)
+			x := c.checkExpression(node.A)
+			v := c.checkModifyTarget(node.Value.(string), node.Pos)
+			if v != nil {
+				c.assignable(node.A, x, v.datatype, "in assignment to "+node.Value.(string))
+			}
+		case parser.VARIABLE_ASSIGN_ARRAY:
+			node.Value = c.qualify(node.Value.(string), node.Pos)
+			index := c.checkScalar(node.A)
+			if !isInvalid(index) && !isInteger(index) {
+				c.error(node.A.Pos, "Index has to be an integer but was "+index.String())
+			}
+			x := c.checkExpression(node.B)
+			v := c.checkAssignTarget(node.Value.(string), node.Pos)
+			if v != nil {
+				d := normalize(v.datatype)
+				if indirect(d) {
+					c.checkBounds(node.A, v, node.Value.(string))
+					c.assignable(node.B, x, element(d), "in assignment to "+node.Value.(string)+"[]")
+				} else {
+					c.error(node.Pos, "Cannot index "+v.datatype.String())
+				}
+			}
+		case parser.FIELD_ASSIGN:
+			x := c.checkExpression(node.B)
+			d := c.checkField(node.A)
+			c.assignable(node.B, x, d, "in assignment to field "+node.A.Value.(string))
+		case parser.DEREFERENCE_ASSIGN:
+			x := c.checkExpression(node.B)
+			d := c.checkExpression(node.A)
+			c.assignable(node.B, x, d, "in assignment through pointer")
+		case parser.VARIABLE_INCREASE, parser.VARIABLE_DECREASE:
+			node.Value = c.qualify(node.Value.(string), node.Pos)
+			v := c.checkModifyTarget(node.Value.(string), node.Pos)
+			if v != nil && !isInteger(v.datatype) && !isPointer(v.datatype) && !isFloat(v.datatype) {
+				c.error(node.Pos, "Cannot modify "+v.datatype.String())
+			}
+			if v != nil {
+				node.Datatype = v.datatype
+			}
+		case parser.FUNCTION_CALL:
+			c.checkFunctionCall(node)
+		case parser.RETURN:
+			returnDatatype := c.current.ReturnDatatype
+			if node.A == nil {
+				if !isVoid(returnDatatype) {
+					c.error(node.Pos, "Missing return value in function "+c.current.Name+" returning "+returnDatatype.String())
+				}
+			} else if isVoid(returnDatatype) {
+				c.checkExpression(node.A)
+				c.error(node.Pos, "Function "+c.current.Name+" returns void")
+			} else {
+				c.assignable(node.A, c.checkExpression(node.A), returnDatatype, "as return value of "+c.current.Name)
+			}
+		case parser.IF:
+			c.checkScalar(node.A)
+			iff := node.Value.(parser.If)
+			c.checkScope(iff.TrueBlock)
+			c.checkScope(iff.FalseBlock)
+		case parser.SWITCH:
+			c.checkSwitch(node)
+		case parser.CONDITIONAL_LOOP, parser.POST_CONDITIONAL_LOOP:
+			c.checkLoop(node)
+		case parser.LOOP:
+			c.checkLoop(node)
+		case parser.BREAK, parser.CONTINUE:
+			c.checkLoopControl(node)
+		case parser.END_EXEC:
+			c.checkScope(node.Value.([]*parser.Node))
+		case parser.ASSEMBLY_CODE:
+		case parser.INLINE_ASSEMBLY:
+			c.checkScalar(node)
+		default:
+			// only calls and asm are useful for their side effects
+			c.checkScalar(node)
+			c.error(node.Pos, "Expression result is unused")
+		}
+	}
+}
+
+func (c *Checker) checkSwitch(node *parser.Node) {
+	d := c.checkScalar(node.A)
+	if !isInvalid(d) && !isInteger(d) {
+		c.error(node.A.Pos, "Switch expression has to be an integer but was "+d.String())
+	}
+
+	s := node.Value.(parser.Switch)
+	// position of the first case label for every value
+	values := make(map[int]int)
+	for _, cs := range s.Cases {
+		for _, v := range cs.Values {
+			c.checkExpression(v)
+			value, err := constexpr.Evaluate(v)
+			if err != nil {
+				c.error(v.Pos, "Case value has to be a constant integer: "+err.Error())
+				continue
+			}
+			if first, ok := values[value]; ok {
+				c.error(v.Pos, "Duplicate case value "+strconv.Itoa(value), c.reporter.Note(first, "first used here"))
+				continue
+			}
+			values[value] = v.Pos
+		}
+		c.checkScope(cs.Body)
+	}
+	if s.Default != nil {
+		c.checkScope(s.Default)
+	}
+}
+
+func (c *Checker) checkLoop(node *parser.Node) {
+	loop := node.Value.(parser.Loop)
+	if loop.Label != "" && utils.IndexOf(c.loops, loop.Label) != -1 {
+		c.error(node.Pos, "Duplicate loop label "+loop.Label)
+	}
+
+	// the variable of a for loop is visible in the condition, the body and the update
+	c.pushScope()
+	if loop.Init != nil {
+		c.checkCodeBlock([]*parser.Node{loop.Init})
+	}
+	if node.A != nil {
+		c.checkScalar(node.A)
+	}
+
+	c.loops = append(c.loops, loop.Label)
+	c.checkScope(loop.Body)
+	if loop.Update != nil {
+		c.checkCodeBlock([]*parser.Node{loop.Update})
+	}
+	c.loops = c.loops[:len(c.loops)-1]
+	c.popScope()
+}
+
+func (c *Checker) checkLoopControl(node *parser.Node) {
+	keyword := "break"
+	if node.Type == parser.CONTINUE {
+		keyword = "continue"
+	}
+
+	if len(c.loops) == 0 {
+		c.error(node.Pos, keyword+" outside of loop")
+		return
+	}
+
+	label := node.Value.(string)
+	if label != "" && utils.IndexOf(c.loops, label) == -1 {
+		c.error(node.Pos, "Unknown loop label "+label)
+	}
+}
+
+func (c *Checker) checkFunction(f parser.Function) {
+	c.current = &f
+	c.module = moduleOf(f.Name)
+	c.scopes = []map[string]symbol{}
+	c.loops = []string{}
+
+	// arguments share the scope of the function body, duplicates are reported by declareFunction
+	c.pushScope()
+	for _, argument := range f.Arguments {
+		c.scopes[0][argument.Name] = symbol{datatype: argument.UnnamedDatatype, pos: -1, final: false}
+	}
+
+	c.checkCodeBlock(f.Body)
+	c.popScope()
+}
+
+func (c *Checker) declareGlobal(name string, s symbol) {
+	if previous, ok := c.globals[name]; ok {
+		c.error(s.pos, "Duplicate definition of "+name, c.reporter.Note(previous.pos, "previous definition of "+name))
+		return
+	}
+	c.globals[name] = s
+}
+
+func (c *Checker) declareFunction(node *parser.Node) {
+	f := node.Value.(parser.Function)
+
+	if previous, ok := c.functions[f.Name]; ok {
+		c.error(node.Pos, "Duplicate definition of function "+f.Name, c.reporter.Note(previous.pos, "previous definition of "+f.Name))
+		return
+	}
+
+	names := []string{}
+	for _, argument := range f.Arguments {
+		if utils.IndexOf(names, argument.Name) != -1 {
+			c.error(node.Pos, "Duplicate argument "+argument.Name+" in function "+f.Name)
+		}
+		if isVoid(argument.UnnamedDatatype) {
+			c.error(node.Pos, "Argument "+argument.Name+" of "+f.Name+" can't be void")
+		}
+		c.checkFixedArray(argument.UnnamedDatatype, node.Pos, "Argument "+argument.Name+" of "+f.Name)
+		names = append(names, argument.Name)
+	}
+
+	if utils.IndexOf(f.Attributes, parser.Assembly) != -1 {
+		for _, argument := range f.Arguments {
+			if isFloat(argument.UnnamedDatatype) {
+				c.error(node.Pos, "Argument "+argument.Name+" of assembly function "+f.Name+" has to be an integer or pointer")
+			}
+		}
+		if isFloat(f.ReturnDatatype) {
+			c.error(node.Pos, "Assembly function "+f.Name+" has to return an integer or pointer")
+		}
+	}
+
+	c.functions[f.Name] = function{function: f, pos: node.Pos}
+}
+
+func (c *Checker) declareOffset(node *parser.Node) {
+	offset := node.Value.(parser.Offset)
+	final := symbol{datatype: integer, pos: node.Pos, final: true}
+
+	for _, entry := range offset.Entries {
+		c.declareGlobal(offset.Name+"_"+entry.Name, final)
+	}
+	c.declareGlobal(offset.Name+"_size", final)
+}
+
+func (c *Checker) declareStruct(node *parser.Node) {
+	s := node.Value.(parser.Offset)
+
+	if _, ok := c.structs[s.Name]; ok {
+		c.error(node.Pos, "Duplicate definition of struct "+s.Name)
+		return
+	}
+
+	names := []string{}
+	for _, entry := range s.Entries {
+		if utils.IndexOf(names, entry.Name) != -1 {
+			c.error(node.Pos, "Duplicate field "+entry.Name+" in struct "+s.Name)
+		}
+		if isVoid(entry.UnnamedDatatype) {
+			c.error(node.Pos, "Field "+entry.Name+" of "+s.Name+" can't be void")
+		}
+		c.checkFixedArray(entry.UnnamedDatatype, node.Pos, "Field "+entry.Name+" of "+s.Name)
+		names = append(names, entry.Name)
+	}
+
+	c.structs[s.Name] = s
+	c.declareOffset(node)
+}
+
+func (c *Checker) Check() {
+	global := c.global.Value.([]*parser.Node)
+
+	for _, node := range global {
+		switch node.Type {
+		case parser.VARIABLE_DECLARATION:
+			datatype := node.Value.(parser.GlobalVariable)
+			if isVoid(datatype.UnnamedDatatype) {
+				c.error(node.Pos, "Variable "+datatype.Name+" can't be void")
+			}
+			c.checkFixedArray(datatype.UnnamedDatatype, node.Pos, "Global variable "+datatype.Name)
+			exported := utils.IndexOf(datatype.Attributes, parser.Global) != -1
+			c.declareGlobal(datatype.Name, symbol{datatype: datatype.UnnamedDatatype, pos: node.Pos, final: false, exported: exported})
+		case parser.OFFSET:
+			c.declareOffset(node)
+		case parser.STRUCT_DECLARATION:
+			c.declareStruct(node)
+		case parser.FUNCTION:
+			c.declareFunction(node)
+		case parser.IMPORT:
+			i := node.Value.(parser.Import)
+			if c.imports[i.Importer] == nil {
+				c.imports[i.Importer] = make(map[string]string)
+			}
+			c.imports[i.Importer][i.Alias] = i.Module
+		}
+	}
+
+	for _, node := range global {
+		if node.Type == parser.VARIABLE_DECLARATION && node.A != nil {
+			datatype := node.Value.(parser.GlobalVariable)
+			c.module = moduleOf(datatype.Name)
+			c.checkInitializer(node.A, datatype.UnnamedDatatype, datatype.Name)
+			if node.A.Type == parser.ARRAY_LITERAL {
+				// global arrays are emitted as constants
+				for _, element := range node.A.Value.([]*parser.Node) {
+					if element.Type != parser.STRING && !isConstant(element) {
+						c.error(element.Pos, "Elements of global array "+datatype.Name+" have to be constant")
+					}
+				}
+			}
+		}
+	}
+
+	for _, node := range global {
+		if node.Type == parser.FUNCTION {
+			c.checkFunction(node.Value.(parser.Function))
+		}
+	}
+}
@@ -1,72 +1,242 @@
 package firestorm
 
 import (
+	"encoding/json"
+	"errors"
+	"fire/arguments"
+	"fire/firestorm/checker"
+	"fire/firestorm/diagnostic"
 	"fire/firestorm/target/llvm"
 	"fmt"
 	"io/fs"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
-func Compile(input string, output string, target string, includes []string) {
+// Profiles map the build profiles to their optimization level.
+var Profiles = map[string]string{
+	"debug":   "0",
+	"release": "2",
+}
+
+// CheckOptimization returns an error if level isn't one of the levels clang and opt accept after -O.
+func CheckOptimization(level string) error {
+	switch level {
+	case "0", "1", "2", "3", "s", "z":
+		return nil
+	}
+	return errors.New("Unknown optimization level " + level + ", expected 0, 1, 2, 3, s or z")
+}
 
-	code, err := os.ReadFile(input)
+// AllowOptimization adds the --opt option to a command.
+func AllowOptimization(parser *arguments.Parser) {
+	parser.Allow("opt", "Optimization level 0, 1, 2, 3, s or z")
+}
+
+// ConsumeOptimization returns the level given with --opt, or "" if the option isn't set.
+func ConsumeOptimization(parser *arguments.Parser) (string, error) {
+	if !parser.Has("opt") {
+		return "", nil
+	}
+	level, err := parser.Consume("opt", nil)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
+	return *level, CheckOptimization(*level)
+}
 
-	preprocessor := NewPreprocessor(includes)
-	processedCode := preprocessor.Process(string(code))
+// Options configure a compilation besides its inputs and output.
+type Options struct {
+	Target   string   `json:"target"`
+	Includes []string `json:"includes"`
+	// Optimization is passed to clang and opt as -O<level>, their default is used if it is empty
+	Optimization string `json:"optimization"`
+	// Cache skips the parts of the compilation whose sources didn't change, nil disables it
+	Cache *Cache `json:"-"`
+}
 
-	lexer := NewLexer(processedCode)
-	tokens := lexer.Tokenize()
+// flags returns the flags passed to clang.
+func (o Options) flags() string {
+	flags := "-target " + o.Target
+	if o.Optimization != "" {
+		flags += " -O" + o.Optimization
+	}
+	return flags
+}
 
-	parser := NewParser(tokens, processedCode)
-	global := parser.Global()
+// key identifies a compilation of inputs to output with the options and the running compiler in the cache.
+func (o Options) key(inputs []string, output string) string {
+	options, _ := json.Marshal(o)
+	return contentHash(append([]string{string(options), output, compilerHash()}, inputs...)...)
+}
 
-	bc := llvm.NewLLVM(global, target)
-	result := bc.Compile()
+// Compile compiles the input files into one program. Executables are linked from one object file per source
+// file or package, .ll and .o outputs contain the whole program. All problems found in the source are returned
+// as diagnostics, the error is set if the compilation failed.
+func Compile(inputs []string, output string, options Options) ([]diagnostic.Diagnostic, error) {
+	key := options.key(inputs, output)
+	if options.Cache.upToDate(output, key) {
+		options.Cache.UpToDate = true
+		return []diagnostic.Diagnostic{}, nil
+	}
+	options.Cache.invalidate(output)
+
+	loader, global, reporter, err := load(inputs, options.Includes)
+	if err != nil {
+		return loadError(err)
+	}
+	if reporter.HasErrors() {
+		return reporter.Diagnostics, compilationFailed(reporter)
+	}
+
+	checker := checker.NewChecker(global, reporter)
+	checker.Check()
+	if reporter.HasErrors() {
+		return reporter.Diagnostics, compilationFailed(reporter)
+	}
 
 	tmp := strings.Split(output, ".")
 	ending := tmp[len(tmp)-1]
 
-	switch ending {
-	case "ll":
-		err = os.WriteFile(output, []byte(result), fs.ModePerm)
+	bc := llvm.NewLLVM(global, options.Target, reporter)
+	if ending == "elf" || ending == "exe" {
+		units := loader.units(global)
+		results := bc.CompileUnits(units)
+		if reporter.HasErrors() {
+			return reporter.Diagnostics, compilationFailed(reporter)
+		}
+		err = link(units, results, output, options)
+	} else {
+		result := bc.Compile()
+		if reporter.HasErrors() {
+			return reporter.Diagnostics, compilationFailed(reporter)
+		}
+
+		switch ending {
+		case "ll":
+			err = writeModule(result, output, options.Optimization)
+		case "o":
+			err = os.WriteFile(output+".ll", []byte(result), fs.ModePerm)
+			if err == nil {
+				err = runCommand(fmt.Sprintf("clang -c %s -o %s %s", output+".ll", output, options.flags()))
+			}
+		}
+	}
+
+	if err == nil {
+		err = options.Cache.store(output, key, loader.preprocessor.read)
+	}
+	return reporter.Diagnostics, err
+}
+
+// link compiles the module of every unit to an object file and links them with a single clang invocation.
+// Objects of modules that were compiled before are taken from the cache.
+func link(units []llvm.Unit, results []string, output string, options Options) error {
+	dir, err := os.MkdirTemp("", "fire-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	flags := options.flags()
+	objects := []string{}
+	for i, unit := range units {
+		cached, ok, err := options.Cache.object(results[i], flags)
 		if err != nil {
-			panic(err)
+			return err
 		}
-	case "o":
-		err = os.WriteFile(output+".ll", []byte(result), fs.ModePerm)
+		if ok {
+			objects = append(objects, cached)
+			continue
+		}
+
+		name := filepath.Join(dir, strconv.Itoa(i)+"_"+unitFileName(unit.Name))
+		err = os.WriteFile(name+".ll", []byte(results[i]), fs.ModePerm)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		runCommand(fmt.Sprintf("clang -c %s -o %s -target %s", output+".ll", output, target))
-	case "elf":
-		fallthrough
-	case "exe":
-		err = os.WriteFile(output+".ll", []byte(result), fs.ModePerm)
+		err = runCommand(fmt.Sprintf("clang -c %s -o %s %s", name+".ll", name+".o", flags))
 		if err != nil {
-			panic(err)
+			return err
+		}
+
+		object := name + ".o"
+		if cached != "" {
+			// the temporary directory may be on another file system
+			err = copyFile(object, cached+".tmp")
+			if err == nil {
+				err = os.Rename(cached+".tmp", cached)
+			}
+			if err != nil {
+				return err
+			}
+			object = cached
 		}
-		runCommand(fmt.Sprintf("clang %s -o %s -target %s", output+".ll", output, target))
+		objects = append(objects, object)
 	}
+
+	return runCommand(fmt.Sprintf("clang %s -o %s %s", strings.Join(objects, " "), output, flags))
+}
+
+// writeModule writes the module to output, it is optimized by opt first if a level is given.
+func writeModule(module string, output string, level string) error {
+	if level == "" {
+		return os.WriteFile(output, []byte(module), fs.ModePerm)
+	}
+
+	dir, err := os.MkdirTemp("", "fire-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "module.ll")
+	err = os.WriteFile(input, []byte(module), fs.ModePerm)
+	if err != nil {
+		return err
+	}
+	return runCommand(fmt.Sprintf("opt -O%s -S %s -o %s", level, input, output))
+}
+
+func copyFile(source string, destination string) error {
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(destination, data, fs.ModePerm)
+}
+
+// unitFileName turns the name of a unit into a file name without directories.
+func unitFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 128 && isIdentifierPart(byte(r)) || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func compilationFailed(reporter *diagnostic.Reporter) error {
+	return errors.New("compilation failed with " + strconv.Itoa(reporter.ErrorCount()) + " error(s)")
 }
 
-func runCommand(command string) {
+func runCommand(command string) error {
 	tmp := strings.Split(command, " ")
 
 	cmd := exec.Command(tmp[0], tmp[1:]...)
 
 	err := cmd.Start()
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	err = cmd.Wait()
 	if err != nil {
 		fmt.Println("[CMD]", command)
-		panic(err)
+		return err
 	}
+	return nil
 }
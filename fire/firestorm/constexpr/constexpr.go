@@ -1,70 +1,223 @@
 package constexpr
 
 import (
+	"errors"
 	"fire/firestorm/parser"
+	"math"
 	"strconv"
 )
 
-func boolToInt(v bool) int {
+type constexprError struct {
+	message string
+}
+
+// Value is the result of a constant expression. Integer operands are promoted to float if the other operand is a float.
+type Value struct {
+	Int     int
+	Float   float64
+	IsFloat bool
+}
+
+func intValue(v int) Value {
+	return Value{Int: v}
+}
+
+func floatValue(v float64) Value {
+	return Value{Float: v, IsFloat: true}
+}
+
+func boolValue(v bool) Value {
 	if v {
-		return 1
+		return intValue(1)
+	}
+	return intValue(0)
+}
+
+func (v Value) float() float64 {
+	if v.IsFloat {
+		return v.Float
+	}
+	return float64(v.Int)
+}
+
+// Fold evaluates a constant expression which may contain floats.
+func Fold(node *parser.Node) (result Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(constexprError); ok {
+				err = errors.New(e.message)
+			} else {
+				panic(r)
+			}
+		}
+	}()
+
+	return evaluate(node), nil
+}
+
+// Evaluate folds a constant expression. Nodes which can't be evaluated at compile time result in an error.
+func Evaluate(node *parser.Node) (int, error) {
+	v, err := Fold(node)
+	if err != nil {
+		return 0, err
+	}
+	if v.IsFloat {
+		return 0, errors.New("Expected integer constant expression but got float")
+	}
+	return v.Int, nil
+}
+
+// EvaluateFloat folds a constant expression and converts the result to float.
+func EvaluateFloat(node *parser.Node) (float64, error) {
+	v, err := Fold(node)
+	if err != nil {
+		return 0, err
 	}
-	return 0
+	return v.float(), nil
+}
+
+func unsupported(node *parser.Node) {
+	panic(constexprError{message: strconv.Itoa(int(node.Type)) + " not supported in constant expression"})
 }
 
-func Evaluate(node *parser.Node) int {
+func integer(node *parser.Node) int {
+	v := evaluate(node)
+	if v.IsFloat {
+		panic(constexprError{message: "Expected integer operand in constant expression"})
+	}
+	return v.Int
+}
+
+func shiftAmount(node *parser.Node) int {
+	amount := integer(node)
+	if amount < 0 {
+		panic(constexprError{message: "Negative shift amount in constant expression"})
+	}
+	return amount
+}
+
+func evaluateArithmetic(node *parser.Node) Value {
+	a := evaluate(node.A)
+	b := evaluate(node.B)
+
+	if a.IsFloat || b.IsFloat {
+		switch node.Type {
+		case parser.ADD:
+			return floatValue(a.float() + b.float())
+		case parser.SUBTRACT:
+			return floatValue(a.float() - b.float())
+		case parser.MULTIPLY:
+			return floatValue(a.float() * b.float())
+		case parser.DIVIDE:
+			return floatValue(a.float() / b.float())
+		case parser.MODULO:
+			return floatValue(math.Mod(a.float(), b.float()))
+		}
+	}
+
 	switch node.Type {
-	case parser.NUMBER:
-		return node.Value.(int)
 	case parser.ADD:
-		return Evaluate(node.A) + Evaluate(node.B)
+		return intValue(a.Int + b.Int)
 	case parser.SUBTRACT:
-		return Evaluate(node.A) - Evaluate(node.B)
+		return intValue(a.Int - b.Int)
 	case parser.MULTIPLY:
-		return Evaluate(node.A) * Evaluate(node.B)
-	case parser.DIVIDE:
-		return Evaluate(node.A) - Evaluate(node.B)
-	case parser.PLUS:
-		return +Evaluate(node.A)
-	case parser.MINUS:
-		return -Evaluate(node.A)
-	case parser.MODULO:
-		return Evaluate(node.A) % Evaluate(node.B)
-	case parser.COMPARE:
+		return intValue(a.Int * b.Int)
+	case parser.DIVIDE, parser.MODULO:
+		if b.Int == 0 {
+			panic(constexprError{message: "Division by zero in constant expression"})
+		}
+		if node.Type == parser.DIVIDE {
+			return intValue(a.Int / b.Int)
+		}
+		return intValue(a.Int % b.Int)
+	}
+	panic("?")
+}
+
+func evaluateCompare(node *parser.Node) Value {
+	a := evaluate(node.A)
+	b := evaluate(node.B)
+
+	if a.IsFloat || b.IsFloat {
+		x, y := a.float(), b.float()
 		switch node.Value.(parser.Compare) {
 		case parser.More:
-			return boolToInt(Evaluate(node.A) > Evaluate(node.B))
+			return boolValue(x > y)
 		case parser.Less:
-			return boolToInt(Evaluate(node.A) < Evaluate(node.B))
+			return boolValue(x < y)
 		case parser.MoreEquals:
-			return boolToInt(Evaluate(node.A) >= Evaluate(node.B))
+			return boolValue(x >= y)
 		case parser.LessEquals:
-			return boolToInt(Evaluate(node.A) <= Evaluate(node.B))
+			return boolValue(x <= y)
 		case parser.Equals:
-			return boolToInt(Evaluate(node.A) == Evaluate(node.B))
+			return boolValue(x == y)
 		case parser.NotEquals:
-			return boolToInt(Evaluate(node.A) != Evaluate(node.B))
+			return boolValue(x != y)
 		}
 		panic("?")
+	}
+
+	switch node.Value.(parser.Compare) {
+	case parser.More:
+		return boolValue(a.Int > b.Int)
+	case parser.Less:
+		return boolValue(a.Int < b.Int)
+	case parser.MoreEquals:
+		return boolValue(a.Int >= b.Int)
+	case parser.LessEquals:
+		return boolValue(a.Int <= b.Int)
+	case parser.Equals:
+		return boolValue(a.Int == b.Int)
+	case parser.NotEquals:
+		return boolValue(a.Int != b.Int)
+	}
+	panic("?")
+}
+
+func evaluate(node *parser.Node) Value {
+	switch node.Type {
+	case parser.NUMBER, parser.CHARACTER:
+		return intValue(node.Value.(int))
+	case parser.FLOAT:
+		return floatValue(node.Value.(float64))
+	case parser.ADD, parser.SUBTRACT, parser.MULTIPLY, parser.DIVIDE, parser.MODULO:
+		return evaluateArithmetic(node)
+	case parser.PLUS:
+		return evaluate(node.A)
+	case parser.MINUS:
+		v := evaluate(node.A)
+		if v.IsFloat {
+			return floatValue(-v.Float)
+		}
+		return intValue(-v.Int)
+	case parser.COMPARE:
+		return evaluateCompare(node)
 	case parser.NOT:
-		if Evaluate(node.A) == 0 {
-			return 1
-		} else {
-			return 0
+		return boolValue(evaluate(node.A).float() == 0)
+	case parser.LOGICAL_AND:
+		if evaluate(node.A).float() == 0 {
+			return intValue(0)
+		}
+		return boolValue(evaluate(node.B).float() != 0)
+	case parser.LOGICAL_OR:
+		if evaluate(node.A).float() != 0 {
+			return intValue(1)
 		}
+		return boolValue(evaluate(node.B).float() != 0)
 	case parser.SHIFT_LEFT:
-		return Evaluate(node.A) << Evaluate(node.B)
+		return intValue(integer(node.A) << shiftAmount(node.B))
 	case parser.SHIFT_RIGHT:
-		return Evaluate(node.A) >> Evaluate(node.B)
+		return intValue(integer(node.A) >> shiftAmount(node.B))
 	case parser.AND:
-		return Evaluate(node.A) & Evaluate(node.B)
+		return intValue(integer(node.A) & integer(node.B))
 	case parser.OR:
-		return Evaluate(node.A) | Evaluate(node.B)
+		return intValue(integer(node.A) | integer(node.B))
 	case parser.XOR:
-		return Evaluate(node.A) ^ Evaluate(node.B)
+		return intValue(integer(node.A) ^ integer(node.B))
 	case parser.BIT_NOT:
-		return ^Evaluate(node.A)
+		return intValue(^integer(node.A))
 	default:
-		panic(strconv.Itoa(int(node.Type)) + " not supported in contant expression")
+		unsupported(node)
+		panic("?")
 	}
 }
@@ -0,0 +1,130 @@
+package diagnostic
+
+import (
+	"fire/firestorm/parser"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	default:
+		return strconv.Itoa(int(s))
+	}
+}
+
+type Diagnostic struct {
+	Severity   Severity
+	File       string
+	Line       int
+	Column     int
+	Message    string
+	LineString string
+	Notes      []Diagnostic
+}
+
+func (d Diagnostic) HasPosition() bool {
+	return d.Line > 0
+}
+
+func (d Diagnostic) String() string {
+	var sb strings.Builder
+
+	sb.WriteString(d.Severity.String() + ": " + d.Message)
+	if d.HasPosition() {
+		sb.WriteString(" (at " + d.File + ":" + strconv.Itoa(d.Line) + ":" + strconv.Itoa(d.Column) + ")\n")
+		sb.WriteString(strings.ReplaceAll(strings.ReplaceAll(d.LineString, "\t", " "), "\r", " ") + "\n")
+		sb.WriteString(strings.Repeat(" ", d.Column-1) + "^")
+	}
+
+	for _, note := range d.Notes {
+		sb.WriteString("\n" + note.String())
+	}
+
+	return sb.String()
+}
+
+type Reporter struct {
+	code        string
+	Diagnostics []Diagnostic
+}
+
+func NewReporter(code string) *Reporter {
+	return &Reporter{
+		code:        code,
+		Diagnostics: []Diagnostic{},
+	}
+}
+
+// New creates a diagnostic for the given offset into the preprocessed code.
+// A negative offset creates a diagnostic without a source location.
+func (r *Reporter) New(severity Severity, pos int, message string) Diagnostic {
+	d := Diagnostic{
+		Severity: severity,
+		Message:  message,
+		Notes:    []Diagnostic{},
+	}
+
+	if pos >= 0 && pos <= len(r.code) {
+		errorLine := parser.FindErrorLineFile(r.code, pos)
+		d.File = errorLine.File
+		d.Line = errorLine.Line
+		d.Column = errorLine.Char + 1
+		d.LineString = errorLine.LineString
+	}
+
+	return d
+}
+
+func (r *Reporter) Report(severity Severity, pos int, message string, notes ...Diagnostic) {
+	d := r.New(severity, pos, message)
+	d.Notes = append(d.Notes, notes...)
+	r.Diagnostics = append(r.Diagnostics, d)
+}
+
+func (r *Reporter) Error(pos int, message string, notes ...Diagnostic) {
+	r.Report(Error, pos, message, notes...)
+}
+
+func (r *Reporter) Warning(pos int, message string, notes ...Diagnostic) {
+	r.Report(Warning, pos, message, notes...)
+}
+
+func (r *Reporter) Note(pos int, message string) Diagnostic {
+	return r.New(Note, pos, message)
+}
+
+func (r *Reporter) ErrorCount() int {
+	count := 0
+	for _, d := range r.Diagnostics {
+		if d.Severity == Error {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *Reporter) HasErrors() bool {
+	return r.ErrorCount() > 0
+}
+
+func Print(diagnostics []Diagnostic) {
+	for _, d := range diagnostics {
+		fmt.Println(d.String())
+	}
+}
@@ -0,0 +1,216 @@
+package doc
+
+import (
+	"errors"
+	"fire/firestorm"
+	"fire/firestorm/diagnostic"
+	"fire/firestorm/parser"
+	"fire/firestorm/target/llvm"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Function is a documented function declaration.
+type Function struct {
+	Name      string
+	Signature string
+	Doc       string
+}
+
+// Field is an entry of an offset or struct with its position in memory.
+type Field struct {
+	Name     string
+	Datatype string
+	Offset   int
+	Size     int
+}
+
+// Offset is a documented offset or struct declaration.
+type Offset struct {
+	Name     string
+	IsStruct bool
+	Fields   []Field
+	Size     int
+	Doc      string
+}
+
+// Global is a documented global variable.
+type Global struct {
+	Name        string
+	Declaration string
+	Doc         string
+}
+
+// File holds the declarations of a single source file, declarations of included files are left out.
+type File struct {
+	Path      string
+	Defines   []firestorm.Define
+	Functions []Function
+	Offsets   []Offset
+	Globals   []Global
+}
+
+// Collect preprocesses and parses the file at path and returns its declarations. Declarations without a ///
+// comment are documented by the // comment lines directly above them.
+func Collect(path string, includes []string, target string) (*File, []diagnostic.Diagnostic, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	preprocessor := firestorm.NewPreprocessor(includes)
+	processedCode, err := preprocessor.Process(string(code))
+	if err != nil {
+		var preprocessorError firestorm.PreprocessorError
+		if errors.As(err, &preprocessorError) {
+			return nil, []diagnostic.Diagnostic{preprocessorError.Diagnostic()}, errors.New("preprocessing " + path + " failed")
+		}
+		return nil, nil, err
+	}
+
+	reporter := diagnostic.NewReporter(processedCode)
+	lexer := firestorm.NewLexer(processedCode, reporter)
+	p := firestorm.NewParser(lexer.Tokenize(), reporter)
+	global := p.Global()
+	if reporter.HasErrors() {
+		return nil, reporter.Diagnostics, errors.New("parsing " + path + " failed with " + strconv.Itoa(reporter.ErrorCount()) + " error(s)")
+	}
+
+	file := &File{
+		Path:    path,
+		Defines: firestorm.Defines(string(code)),
+	}
+	layout := llvm.NewLLVM(global, target, reporter)
+	own := ownRanges(processedCode)
+
+	for _, node := range global.Value.([]*parser.Node) {
+		if !contains(own, node.Pos) {
+			continue
+		}
+
+		switch node.Type {
+		case parser.FUNCTION:
+			f := node.Value.(parser.Function)
+			file.Functions = append(file.Functions, Function{
+				Name:      f.Name,
+				Signature: signature(f),
+				Doc:       comment(f.Doc, processedCode, node.Pos),
+			})
+		case parser.OFFSET, parser.STRUCT_DECLARATION:
+			o := node.Value.(parser.Offset)
+			offsets, sizes, size := layout.Layout(o)
+			offset := Offset{
+				Name:     o.Name,
+				IsStruct: node.Type == parser.STRUCT_DECLARATION,
+				Size:     size,
+				Doc:      comment(o.Doc, processedCode, node.Pos),
+			}
+			for i, entry := range o.Entries {
+				offset.Fields = append(offset.Fields, Field{
+					Name:     entry.Name,
+					Datatype: entry.UnnamedDatatype.String(),
+					Offset:   offsets[i],
+					Size:     sizes[i],
+				})
+			}
+			file.Offsets = append(file.Offsets, offset)
+		case parser.VARIABLE_DECLARATION:
+			g := node.Value.(parser.GlobalVariable)
+			file.Globals = append(file.Globals, Global{
+				Name:        g.Name,
+				Declaration: attributes(g.Attributes, " ") + g.UnnamedDatatype.String() + " " + g.Name,
+				Doc:         comment(g.Doc, processedCode, node.Pos),
+			})
+		}
+	}
+
+	return file, reporter.Diagnostics, nil
+}
+
+func attributes(list []parser.FunctionAttribute, separator string) string {
+	names := []string{}
+	for _, attribute := range list {
+		names = append(names, attribute.String())
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return strings.Join(names, separator) + separator
+}
+
+// signature formats f the way it is declared, without its body.
+func signature(f parser.Function) string {
+	result := "function"
+	if len(f.Attributes) > 0 {
+		result += "(" + strings.TrimSuffix(attributes(f.Attributes, ", "), ", ") + ")"
+	}
+
+	arguments := []string{}
+	for _, argument := range f.Arguments {
+		arguments = append(arguments, argument.UnnamedDatatype.String()+" "+argument.Name)
+	}
+	if f.Variadic {
+		arguments = append(arguments, "...")
+	}
+	return result + " " + f.Name + "(" + strings.Join(arguments, ", ") + ") -> " + f.ReturnDatatype.String()
+}
+
+// ownRanges returns the [start, end) ranges of the processed code which don't belong to an included file.
+// The preprocessor wraps included code in //@file and //@endfile lines.
+func ownRanges(code string) [][2]int {
+	ranges := [][2]int{}
+	depth := 0
+	start := 0
+	pos := 0
+	for _, line := range strings.SplitAfter(code, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "//@file ") {
+			if depth == 0 {
+				ranges = append(ranges, [2]int{start, pos})
+			}
+			depth++
+		} else if trimmed == "//@endfile" {
+			depth--
+			if depth == 0 {
+				start = pos + len(line)
+			}
+		}
+		pos += len(line)
+	}
+	if depth == 0 {
+		ranges = append(ranges, [2]int{start, len(code)})
+	}
+	return ranges
+}
+
+func contains(ranges [][2]int, pos int) bool {
+	for _, r := range ranges {
+		if pos >= r[0] && pos < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// comment returns doc, or the // lines directly above the line containing pos if doc is empty.
+func comment(doc string, code string, pos int) string {
+	if doc != "" {
+		return doc
+	}
+
+	lines := strings.Split(code[:strings.LastIndex(code[:pos], "\n")+1], "\n")
+	// the last element is the empty text after the final newline
+	lines = lines[:len(lines)-1]
+
+	comment := []string{}
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		// //@ lines are markers of the preprocessor and //// lines separators
+		if !strings.HasPrefix(line, "//") || strings.HasPrefix(line, "//@") || strings.HasPrefix(line, "////") {
+			break
+		}
+		comment = append([]string{strings.TrimPrefix(strings.TrimPrefix(line, "//"), " ")}, comment...)
+	}
+	return strings.Join(comment, "\n")
+}
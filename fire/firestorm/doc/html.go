@@ -0,0 +1,86 @@
+package doc
+
+import (
+	"html/template"
+	"strings"
+	"unicode"
+)
+
+var page = template.Must(template.New("page").Funcs(template.FuncMap{
+	"lines": func(s string) []string { return strings.Split(s, "\n") },
+	// anchor turns a path into an id without characters that need escaping
+	"anchor": func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return '-'
+		}, s)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: auto; padding: 1em; }
+pre, code { background: #f4f4f4; }
+pre { padding: 0.5em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<ul>
+{{- range .Files}}
+<li><a href="#{{anchor .Path}}">{{.Path}}</a></li>
+{{- end}}
+</ul>
+{{- range .Files}}
+<h2 id="{{anchor .Path}}">{{.Path}}</h2>
+{{- if .Defines}}
+<h3>Defines</h3>
+<table>
+<tr><th>Name</th><th>Value</th></tr>
+{{- range .Defines}}
+<tr><td><code>{{.Signature}}</code></td><td><code>{{.Value}}</code></td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- range .Offsets}}
+<h3>{{if .IsStruct}}struct{{else}}offset{{end}} {{.Name}}</h3>
+{{- template "doc" .Doc}}
+<table>
+<tr><th>Field</th><th>Type</th><th>Offset</th><th>Size</th></tr>
+{{- range .Fields}}
+<tr><td><code>{{.Name}}</code></td><td><code>{{.Datatype}}</code></td><td>{{.Offset}}</td><td>{{.Size}}</td></tr>
+{{- end}}
+</table>
+<p>Size: {{.Size}} bytes</p>
+{{- end}}
+{{- range .Globals}}
+<h3>{{.Name}}</h3>
+<pre>{{.Declaration}}</pre>
+{{- template "doc" .Doc}}
+{{- end}}
+{{- range .Functions}}
+<h3>{{.Name}}</h3>
+<pre>{{.Signature}}</pre>
+{{- template "doc" .Doc}}
+{{- end}}
+{{- end}}
+</body>
+</html>
+{{define "doc"}}{{if .}}
+<p>{{range $i, $line := lines .}}{{if $i}}<br>{{end}}{{$line}}{{end}}</p>{{end}}{{end}}`))
+
+// HTML renders the documentation of files as a single static HTML page.
+func HTML(title string, files []*File) (string, error) {
+	var b strings.Builder
+	err := page.Execute(&b, struct {
+		Title string
+		Files []*File
+	}{title, files})
+	return b.String(), err
+}
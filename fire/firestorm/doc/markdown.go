@@ -0,0 +1,65 @@
+package doc
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Markdown renders the documentation of files as a single Markdown document.
+func Markdown(title string, files []*File) string {
+	var b strings.Builder
+	b.WriteString("# " + title + "\n")
+
+	for _, file := range files {
+		b.WriteString("\n## " + file.Path + "\n")
+
+		if len(file.Defines) > 0 {
+			b.WriteString("\n### Defines\n")
+			b.WriteString("\n| Name | Value |\n| --- | --- |\n")
+			for _, define := range file.Defines {
+				b.WriteString("| `" + define.Signature() + "` | `" + cell(define.Value) + "` |\n")
+			}
+		}
+
+		for _, offset := range file.Offsets {
+			kind := "offset"
+			if offset.IsStruct {
+				kind = "struct"
+			}
+			b.WriteString("\n### " + kind + " " + offset.Name + "\n")
+			paragraph(&b, offset.Doc)
+			b.WriteString("\n| Field | Type | Offset | Size |\n| --- | --- | --- | --- |\n")
+			for _, field := range offset.Fields {
+				b.WriteString("| `" + field.Name + "` | `" + field.Datatype + "` | " + strconv.Itoa(field.Offset) + " | " + strconv.Itoa(field.Size) + " |\n")
+			}
+			b.WriteString("\nSize: " + strconv.Itoa(offset.Size) + " bytes\n")
+		}
+
+		for _, global := range file.Globals {
+			b.WriteString("\n### " + global.Name + "\n")
+			b.WriteString("\n```\n" + global.Declaration + "\n```\n")
+			paragraph(&b, global.Doc)
+		}
+
+		for _, function := range file.Functions {
+			b.WriteString("\n### " + function.Name + "\n")
+			b.WriteString("\n```\n" + function.Signature + "\n```\n")
+			paragraph(&b, function.Doc)
+		}
+	}
+
+	return b.String()
+}
+
+// paragraph writes a doc comment, single line breaks of the comment are kept.
+func paragraph(b *strings.Builder, doc string) {
+	if doc == "" {
+		return
+	}
+	b.WriteString("\n" + strings.ReplaceAll(doc, "\n", "  \n") + "\n")
+}
+
+// cell escapes the characters which would end a table cell.
+func cell(s string) string {
+	return strings.ReplaceAll(s, "|", "\\|")
+}
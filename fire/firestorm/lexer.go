@@ -1,23 +1,34 @@
 package firestorm
 
 import (
+	"fire/firestorm/diagnostic"
 	"fire/firestorm/lexer"
 	"fire/firestorm/utils"
+	"fmt"
 	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
-	code    string
-	pos     int
-	current rune
+	code     string
+	pos      int
+	current  rune
+	reporter *diagnostic.Reporter
 }
 
-func NewLexer(code string) Lexer {
+func NewLexer(code string, reporter *diagnostic.Reporter) Lexer {
+	return NewLexerAt(code, 0, reporter)
+}
+
+// NewLexerAt lexes code from offset start on, positions of tokens and diagnostics are offsets into the whole code.
+func NewLexerAt(code string, start int, reporter *diagnostic.Reporter) Lexer {
 	l := Lexer{
-		code:    code,
-		pos:     -1,
-		current: 0,
+		code:     code,
+		pos:      start - 1,
+		current:  0,
+		reporter: reporter,
 	}
 	l.advance()
 	return l
@@ -32,15 +43,189 @@ func (l *Lexer) advance() {
 	}
 }
 
+// peek returns the character after the current one without advancing.
+func (l *Lexer) peek() rune {
+	if l.pos+1 < len(l.code) {
+		return rune(l.code[l.pos+1])
+	}
+	return 0
+}
+
+func (l *Lexer) digits() string {
+	num := ""
+	for unicode.IsDigit(l.current) {
+		num += string(l.current)
+		l.advance()
+	}
+	return num
+}
+
+// isLetter reports ASCII letters, the bytes of multibyte characters aren't letters on their own.
+func isLetter(r rune) bool {
+	return r < utf8.RuneSelf && unicode.IsLetter(r)
+}
+
 func (l *Lexer) reverse() {
 	l.pos--
 	l.current = rune(l.code[l.pos])
 }
 
+// escape returns the byte of the escape sequence starting at the current backslash and stops at its last character.
+func (l *Lexer) escape() byte {
+	start := l.pos
+	l.advance()
+	switch l.current {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	case 'r':
+		return '\r'
+	case '0':
+		return 0
+	case '\\', '"', '\'':
+		return byte(l.current)
+	case 'x':
+		digits := ""
+		for len(digits) < 2 && strings.ContainsRune("0123456789abcdefABCDEF", l.peek()) {
+			l.advance()
+			digits += string(l.current)
+		}
+		if len(digits) != 2 {
+			l.reporter.Error(start, "Expected two hex digits after \\x")
+			return 0
+		}
+		value, _ := strconv.ParseUint(digits, 16, 8)
+		return byte(value)
+	case 0, '\n':
+		l.reverse()
+		l.reporter.Error(start, "Incomplete escape sequence")
+		return 0
+	default:
+		l.reporter.Error(start, "Unknown escape sequence \\"+string(l.current))
+		return byte(l.current)
+	}
+}
+
+// string lexes a string literal starting at the current quote and stops at the closing quote.
+func (l *Lexer) string() lexer.Token {
+	start := l.pos
+	str := []byte{}
+	l.advance()
+	for l.current != '"' {
+		if l.current == 0 || l.current == '\n' {
+			l.reporter.Error(start, "Unterminated string literal")
+			// the newline or end of file is handled by the main loop
+			l.reverse()
+			break
+		}
+		if l.current == '\\' {
+			str = append(str, l.escape())
+		} else {
+			str = append(str, byte(l.current))
+		}
+		l.advance()
+	}
+	return lexer.NewToken(lexer.STRING, string(str), start)
+}
+
+// character lexes a character literal like 'a' or '\n' starting at the current quote and stops at the closing quote.
+func (l *Lexer) character() lexer.Token {
+	start := l.pos
+	l.advance()
+
+	var chr byte
+	switch l.current {
+	case '\'':
+		l.reporter.Error(start, "Empty character literal")
+		return lexer.NewToken(lexer.CHARACTER, 0, start)
+	case 0, '\n':
+		l.reporter.Error(start, "Unterminated character literal")
+		l.reverse()
+		return lexer.NewToken(lexer.CHARACTER, 0, start)
+	case '\\':
+		chr = l.escape()
+	default:
+		chr = byte(l.current)
+	}
+
+	l.advance()
+	if l.current != '\'' {
+		for l.current != '\'' && l.current != '\n' && l.current != 0 {
+			l.advance()
+		}
+		if l.current == '\'' {
+			l.reporter.Error(start, "Character literal can only contain one character")
+		} else {
+			l.reporter.Error(start, "Unterminated character literal")
+			l.reverse()
+		}
+	}
+	return lexer.NewToken(lexer.CHARACTER, int(chr), start)
+}
+
+// line returns the rest of the current line without a single leading space and stops before the newline.
+func (l *Lexer) line() string {
+	start := l.pos
+	for l.current != 0 && l.current != '\n' {
+		l.advance()
+	}
+	text := strings.TrimSuffix(l.code[start:l.pos], "\r")
+	l.reverse()
+	return strings.TrimPrefix(text, " ")
+}
+
+// blockComment skips a /* */ comment starting at the current *, comments nest.
+// It stops at the final / of the comment.
+func (l *Lexer) blockComment() {
+	start := l.pos - 1
+	depth := 1
+	l.advance()
+	for {
+		switch {
+		case l.current == 0:
+			l.reporter.Error(start, "Unterminated block comment")
+			l.reverse()
+			return
+		case l.current == '/' && l.peek() == '*':
+			depth++
+			l.advance()
+		case l.current == '*' && l.peek() == '/':
+			depth--
+			l.advance()
+			if depth == 0 {
+				return
+			}
+		}
+		l.advance()
+	}
+}
+
+// illegal reports the character at the current position, which may be the first byte of a multibyte character.
+func (l *Lexer) illegal() {
+	r, size := utf8.DecodeRuneInString(l.code[l.pos:])
+	if unicode.IsPrint(r) {
+		l.reporter.Error(l.pos, "Illegal character "+string(r))
+	} else {
+		l.reporter.Error(l.pos, fmt.Sprintf("Illegal character %U", r))
+	}
+	for i := 1; i < size; i++ {
+		l.advance()
+	}
+}
+
 func (l *Lexer) Tokenize() []lexer.Token {
 	tokens := []lexer.Token{}
+	// doc comment lines waiting for the token at index docToken
+	doc := []string{}
+	docToken := 0
 
 	for l.current != 0 {
+		if len(doc) > 0 && len(tokens) > docToken {
+			tokens[docToken].Doc = strings.Join(doc, "\n")
+			doc = []string{}
+		}
+
 		if unicode.IsDigit(l.current) {
 			start := l.pos
 
@@ -69,21 +254,56 @@ func (l *Lexer) Tokenize() []lexer.Token {
 				l.advance()
 			}
 
-			value, err := strconv.ParseInt(num, base, 64)
-			if err != nil {
-				panic(err)
+			isFloat := false
+			if base == 10 && l.current == '.' && unicode.IsDigit(l.peek()) {
+				isFloat = true
+				l.advance()
+				num += "." + l.digits()
 			}
-			tokens = append(tokens, lexer.NewToken(lexer.NUMBER, int(value), start))
+			if base == 10 && (l.current == 'e' || l.current == 'E') {
+				next := l.peek()
+				if unicode.IsDigit(next) || next == '+' || next == '-' {
+					isFloat = true
+					num += "e"
+					l.advance()
+					if l.current == '+' || l.current == '-' {
+						num += string(l.current)
+						l.advance()
+					}
+					exponent := l.digits()
+					if exponent == "" {
+						l.reporter.Error(start, "Invalid number "+num)
+					}
+					num += exponent
+				}
+			}
+
+			if isFloat {
+				value, err := strconv.ParseFloat(num, 64)
+				if err != nil {
+					l.reporter.Error(start, "Invalid number "+num)
+				}
+				tokens = append(tokens, lexer.NewToken(lexer.FLOAT, value, start))
+			} else {
+				// parsed as unsigned so u64 constants above the int range can be written
+				value, err := strconv.ParseUint(num, base, 64)
+				if err != nil {
+					l.reporter.Error(start, "Invalid number "+num)
+				}
+				tokens = append(tokens, lexer.NewToken(lexer.NUMBER, int(value), start))
+			}
+			continue
 		}
 
-		if unicode.IsLetter(l.current) {
+		if isLetter(l.current) {
 			start := l.pos
 			id := ""
-			for unicode.IsLetter(l.current) || unicode.IsDigit(l.current) || l.current == '_' {
+			for isLetter(l.current) || unicode.IsDigit(l.current) || l.current == '_' {
 				id += string(l.current)
 				l.advance()
 			}
 			tokens = append(tokens, lexer.NewToken(lexer.ID, id, start))
+			continue
 		}
 
 		if unicode.IsSpace(l.current) {
@@ -93,13 +313,7 @@ func (l *Lexer) Tokenize() []lexer.Token {
 
 		switch l.current {
 		case '\'':
-			l.advance()
-			chr := l.current
-			l.advance()
-			if l.current != '\'' {
-				panic("Expected '")
-			}
-			tokens = append(tokens, lexer.NewToken(lexer.NUMBER, int(chr), l.pos))
+			tokens = append(tokens, l.character())
 		case '(':
 			tokens = append(tokens, lexer.NewToken(lexer.LPAREN, nil, l.pos))
 		case ')':
@@ -114,6 +328,16 @@ func (l *Lexer) Tokenize() []lexer.Token {
 			tokens = append(tokens, lexer.NewToken(lexer.RBRACKET, nil, l.pos))
 		case ',':
 			tokens = append(tokens, lexer.NewToken(lexer.COMMA, nil, l.pos))
+		case '.':
+			if l.peek() == '.' && l.pos+2 < len(l.code) && l.code[l.pos+2] == '.' {
+				tokens = append(tokens, lexer.NewToken(lexer.ELLIPSIS, nil, l.pos))
+				l.advance()
+				l.advance()
+			} else {
+				tokens = append(tokens, lexer.NewToken(lexer.DOT, nil, l.pos))
+			}
+		case ':':
+			tokens = append(tokens, lexer.NewToken(lexer.COLON, nil, l.pos))
 		case '+':
 			l.advance()
 			if l.current == '+' {
@@ -137,9 +361,21 @@ func (l *Lexer) Tokenize() []lexer.Token {
 		case '^':
 			tokens = append(tokens, lexer.NewToken(lexer.XOR, nil, l.pos))
 		case '|':
-			tokens = append(tokens, lexer.NewToken(lexer.OR, nil, l.pos))
+			l.advance()
+			if l.current == '|' {
+				tokens = append(tokens, lexer.NewToken(lexer.LOGICAL_OR, nil, l.pos-1))
+			} else {
+				l.reverse()
+				tokens = append(tokens, lexer.NewToken(lexer.OR, nil, l.pos))
+			}
 		case '&':
-			tokens = append(tokens, lexer.NewToken(lexer.AND, nil, l.pos))
+			l.advance()
+			if l.current == '&' {
+				tokens = append(tokens, lexer.NewToken(lexer.LOGICAL_AND, nil, l.pos-1))
+			} else {
+				l.reverse()
+				tokens = append(tokens, lexer.NewToken(lexer.AND, nil, l.pos))
+			}
 		case '~':
 			tokens = append(tokens, lexer.NewToken(lexer.BIT_NOT, nil, l.pos))
 		case ';':
@@ -186,28 +422,34 @@ func (l *Lexer) Tokenize() []lexer.Token {
 			l.advance()
 			if l.current == '/' {
 				l.advance()
+				// //// and longer are separators, not doc comments
+				if l.current == '/' && l.peek() != '/' {
+					l.advance()
+					doc = append(doc, l.line())
+					docToken = len(tokens)
+				}
 				for l.current != 0 && l.current != '\n' {
 					l.advance()
 				}
+			} else if l.current == '*' {
+				l.blockComment()
 			} else {
 				l.reverse()
 				tokens = append(tokens, lexer.NewToken(lexer.DIVIDE, nil, l.pos))
 			}
 		case '"':
-			start := l.pos
-			str := ""
-			l.advance()
-			for l.current != '"' {
-				str += string(l.current)
-				l.advance()
-			}
-			tokens = append(tokens, lexer.NewToken(lexer.STRING, str, start))
+			tokens = append(tokens, l.string())
 		default:
-			panic("Illegal token " + string(l.current))
+			l.illegal()
 		}
 
 		l.advance()
 	}
 
+	tokens = append(tokens, lexer.NewToken(lexer.END_OF_FILE, nil, len(l.code)))
+	if len(doc) > 0 {
+		tokens[docToken].Doc = strings.Join(doc, "\n")
+	}
+
 	return tokens
 }
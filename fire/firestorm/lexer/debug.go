@@ -36,6 +36,14 @@ var debug = map[TokenType]string{
 	NOT:         "!",
 	INCREASE:    "++",
 	DECREASE:    "--",
+	DOT:         ".",
+	FLOAT:       "float",
+	CHARACTER:   "character",
+	COLON:       ":",
+	LOGICAL_AND: "&&",
+	LOGICAL_OR:  "||",
+	ELLIPSIS:    "...",
+	END_OF_FILE: "end of file",
 }
 
 func ToString(token TokenType) string {
@@ -38,12 +38,28 @@ const (
 
 	INCREASE
 	DECREASE
+
+	DOT
+
+	FLOAT
+	CHARACTER
+
+	COLON
+
+	LOGICAL_AND
+	LOGICAL_OR
+
+	ELLIPSIS
+
+	END_OF_FILE
 )
 
 type Token struct {
 	Type  TokenType
 	Value any
 	Pos   int
+	// Doc holds the /// comment lines directly before the token
+	Doc string
 }
 
 func NewToken(tokenType TokenType, value any, pos int) Token {
@@ -0,0 +1,321 @@
+package firestorm
+
+import (
+	"errors"
+	"fire/firestorm/utils"
+	"strconv"
+	"strings"
+)
+
+// Define is a macro created by $define. Function-like macros like MAX(a, b) have Parameters.
+type Define struct {
+	Name  string
+	Value string
+	// IsFunction is set for function-like macros, which may have no parameters
+	IsFunction bool
+	Parameters []string
+}
+
+// Signature returns the name of the macro followed by its parameters if it is function-like.
+func (d Define) Signature() string {
+	if d.IsFunction {
+		return d.Name + "(" + strings.Join(d.Parameters, ", ") + ")"
+	}
+	return d.Name
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentifierPart(c byte) bool {
+	return isIdentifierStart(c) || (c >= '0' && c <= '9')
+}
+
+// identifier returns the length of the identifier at the start of s.
+func identifier(s string) int {
+	if s == "" || !isIdentifierStart(s[0]) {
+		return 0
+	}
+	i := 1
+	for i < len(s) && isIdentifierPart(s[i]) {
+		i++
+	}
+	return i
+}
+
+// quoted returns the length of the string or character literal at the start of s, which ends at the line end
+// if it isn't terminated. The lexer reports unterminated literals.
+func quoted(s string) int {
+	quote := s[0]
+	i := 1
+	for i < len(s) && s[i] != quote && s[i] != '\n' {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		i++
+	}
+	if i < len(s) && s[i] == quote {
+		i++
+	}
+	return i
+}
+
+// parseDefine parses the text following $define.
+func parseDefine(text string) (Define, error) {
+	text = strings.TrimSpace(text)
+	n := identifier(text)
+	if n == 0 {
+		return Define{}, errors.New("Expected macro name after $define")
+	}
+	define := Define{Name: text[:n]}
+	rest := text[n:]
+
+	// a parenthesis directly after the name starts the parameter list
+	if strings.HasPrefix(rest, "(") {
+		end := strings.IndexByte(rest, ')')
+		if end == -1 {
+			return Define{}, errors.New("Expected ) after parameters of macro " + define.Name)
+		}
+		define.IsFunction = true
+		define.Parameters = []string{}
+		if parameters := strings.TrimSpace(rest[1:end]); parameters != "" {
+			for _, parameter := range strings.Split(parameters, ",") {
+				parameter = strings.TrimSpace(parameter)
+				if identifier(parameter) != len(parameter) {
+					return Define{}, errors.New("Invalid parameter '" + parameter + "' of macro " + define.Name)
+				}
+				define.Parameters = append(define.Parameters, parameter)
+			}
+		}
+		rest = rest[end+1:]
+	} else if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return Define{}, errors.New("Expected space after macro name " + define.Name)
+	}
+
+	define.Value = strings.TrimSpace(stripComment(rest))
+	return define, nil
+}
+
+// stripComment removes a // comment from the end of a directive line.
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '"' || line[i] == '\'':
+			i += quoted(line[i:]) - 1
+		case strings.HasPrefix(line[i:], "//"):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// macroExpander replaces macros in code outside of string literals and comments.
+type macroExpander struct {
+	defines map[string]Define
+	// depth of the nested /* */ comment a line ends in, comments can span lines
+	commentDepth int
+}
+
+// expand replaces the macros in line, macros named in disabled are left alone to stop recursion.
+func (m *macroExpander) expand(line string, disabled []string) (string, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(line); {
+		if m.commentDepth > 0 {
+			switch {
+			case strings.HasPrefix(line[i:], "/*"):
+				m.commentDepth++
+				b.WriteString("/*")
+				i += 2
+			case strings.HasPrefix(line[i:], "*/"):
+				m.commentDepth--
+				b.WriteString("*/")
+				i += 2
+			default:
+				b.WriteByte(line[i])
+				i++
+			}
+			continue
+		}
+
+		c := line[i]
+		switch {
+		case strings.HasPrefix(line[i:], "//"):
+			b.WriteString(line[i:])
+			return b.String(), nil
+		case strings.HasPrefix(line[i:], "/*"):
+			m.commentDepth++
+			b.WriteString("/*")
+			i += 2
+		case c == '"' || c == '\'':
+			n := quoted(line[i:])
+			b.WriteString(line[i : i+n])
+			i += n
+		case c >= '0' && c <= '9':
+			// numbers like 0xff or 1e5 contain letters which aren't identifiers
+			start := i
+			for i < len(line) && (isIdentifierPart(line[i]) || line[i] == '.') {
+				i++
+			}
+			b.WriteString(line[start:i])
+		case isIdentifierStart(c):
+			start := i
+			n := identifier(line[i:])
+			name := line[i : i+n]
+			i += n
+
+			define, ok := m.defines[name]
+			if !ok || utils.IndexOf(disabled, name) != -1 {
+				b.WriteString(name)
+				continue
+			}
+
+			// values and arguments are scanned on their own, the comment state of the line doesn't apply to them
+			nested := macroExpander{defines: m.defines}
+			value := define.Value
+			if define.IsFunction {
+				arguments, length, ok, err := macroArguments(line[i:], name)
+				if err != nil {
+					return "", at(start, err)
+				}
+				if !ok {
+					// a function-like macro without arguments is a plain identifier
+					b.WriteString(name)
+					continue
+				}
+				i += length
+
+				if len(arguments) != len(define.Parameters) {
+					return "", at(start, errors.New("Macro "+name+" expects "+strconv.Itoa(len(define.Parameters))+" argument(s) but got "+strconv.Itoa(len(arguments))))
+				}
+				substitutions := map[string]string{}
+				for j, parameter := range define.Parameters {
+					// arguments are expanded before they are substituted
+					expanded, err := nested.expand(arguments[j], disabled)
+					if err != nil {
+						return "", at(start, err)
+					}
+					substitutions[parameter] = expanded
+				}
+				value = substitute(value, substitutions)
+			}
+
+			expanded, err := nested.expand(value, append(append([]string{}, disabled...), name))
+			if err != nil {
+				return "", at(start, err)
+			}
+			b.WriteString(expanded)
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return b.String(), nil
+}
+
+// macroError is an error in the expansion of the macro used at pos of the expanded line.
+type macroError struct {
+	pos int
+	err error
+}
+
+func (e macroError) Error() string {
+	return e.err.Error()
+}
+
+func (e macroError) Unwrap() error {
+	return e.err
+}
+
+// at adds the position of a macro use to err. Errors of nested expansions are in a macro value or argument, so they
+// get the position of the macro that is expanded.
+func at(pos int, err error) error {
+	var macro macroError
+	if errors.As(err, &macro) {
+		err = macro.err
+	}
+	return macroError{pos: pos, err: err}
+}
+
+// unterminatedError is returned for a macro call whose argument list isn't closed, it may continue on the next line.
+type unterminatedError struct {
+	name string
+}
+
+func (e unterminatedError) Error() string {
+	return "Unterminated argument list of macro " + e.name
+}
+
+// macroArguments splits the argument list of a function-like macro call at the start of s, commas inside
+// parentheses and literals don't separate arguments. ok is false if s doesn't start with a (.
+func macroArguments(s string, name string) (arguments []string, length int, ok bool, err error) {
+	i := 0
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+	if i == len(s) || s[i] != '(' {
+		return nil, 0, false, nil
+	}
+	i++
+
+	depth := 0
+	start := i
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'':
+			i += quoted(s[i:]) - 1
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				argument := strings.TrimSpace(s[start:i])
+				if argument != "" || len(arguments) > 0 {
+					arguments = append(arguments, argument)
+				}
+				return arguments, i + 1, true, nil
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				arguments = append(arguments, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return nil, 0, false, unterminatedError{name: name}
+}
+
+// substitute replaces the parameters in the value of a function-like macro with the arguments.
+func substitute(value string, substitutions map[string]string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\'':
+			n := quoted(value[i:])
+			b.WriteString(value[i : i+n])
+			i += n
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(value) && (isIdentifierPart(value[i]) || value[i] == '.') {
+				i++
+			}
+			b.WriteString(value[start:i])
+		case isIdentifierStart(c):
+			n := identifier(value[i:])
+			name := value[i : i+n]
+			if argument, ok := substitutions[name]; ok {
+				b.WriteString(argument)
+			} else {
+				b.WriteString(name)
+			}
+			i += n
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
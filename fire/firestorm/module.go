@@ -0,0 +1,258 @@
+package firestorm
+
+import (
+	"errors"
+	"fire/firestorm/diagnostic"
+	"fire/firestorm/lexer"
+	"fire/firestorm/parser"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// module is a file loaded by Load. The main file is the module without a name, the declarations of imported
+// modules are prefixed with their name.
+type module struct {
+	name string
+	path string
+	// start and end of the module in the combined code
+	start int
+	end   int
+	// end of the code of the module itself, included files are appended after it
+	own int
+	// module name of every imported path
+	imports map[string]string
+}
+
+// loader collects the preprocessed code of the main file and of all modules imported by it.
+type loader struct {
+	preprocessor Preprocessor
+	code         strings.Builder
+	modules      []*module
+	// module name of every loaded file
+	loaded map[string]string
+}
+
+// moduleName derives a unique module name from the file name, it has to be a valid identifier.
+func (l *loader) moduleName(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	name := strings.Map(func(r rune) rune {
+		if r < 128 && isIdentifierPart(byte(r)) {
+			return r
+		}
+		return '_'
+	}, base)
+	if name == "" || !isIdentifierStart(name[0]) {
+		name = "_" + name
+	}
+
+	unique := name
+	for i := 2; ; i++ {
+		taken := false
+		for _, m := range l.modules {
+			taken = taken || m.name == unique
+		}
+		if !taken {
+			return unique
+		}
+		unique = name + "_" + strconv.Itoa(i)
+	}
+}
+
+// add preprocesses a file and appends it to the combined code. Every file is wrapped in //@file markers, so
+// diagnostics report its own file and line.
+func (l *loader) add(name string, path string, code string) (*module, error) {
+	// defines don't leak from one module into another, included files only add their declarations once
+	l.preprocessor.defines = map[string]Define{}
+	l.preprocessor.moduleIncludes = nil
+	processed, err := l.preprocessor.processFile(path, code)
+	if err != nil {
+		return nil, err
+	}
+
+	own := len(processed)
+	if i := strings.Index(processed, "\n//@file "); i != -1 {
+		own = i
+	}
+
+	l.code.WriteString("\n//@file " + path + "\n")
+	m := &module{name: name, path: path, start: l.code.Len(), imports: map[string]string{}}
+	m.own = m.start + own
+	l.code.WriteString(processed)
+	m.end = l.code.Len()
+	l.code.WriteString("\n//@endfile")
+
+	l.modules = append(l.modules, m)
+	return m, nil
+}
+
+// importError creates an error at the position of an import in the processed code of m.
+func (l *loader) importError(m *module, pos int, message string) error {
+	code := l.code.String()[:m.end]
+	location := parser.FindErrorLineFile(code, pos)
+	return PreprocessorError{File: location.File, Line: location.Line, Column: location.Char + 1, LineString: location.LineString, Message: message}
+}
+
+// resolve loads the modules imported by m. Paths are relative to the importing file or one of the include paths.
+func (l *loader) resolve(m *module) error {
+	reporter := diagnostic.NewReporter(l.code.String())
+	lex := NewLexerAt(l.code.String()[:m.end], m.start, reporter)
+	tokens := lex.Tokenize()
+
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].Type != lexer.ID || tokens[i].Value != "import" || tokens[i+1].Type != lexer.STRING {
+			continue
+		}
+		path := tokens[i+1].Value.(string)
+		if _, ok := m.imports[path]; ok {
+			continue
+		}
+
+		file := filepath.Join(filepath.Dir(m.path), path)
+		code := l.preprocessor.tryRead(file)
+		if code == nil {
+			file, code = l.preprocessor.find(path)
+		}
+		if code == nil {
+			return l.importError(m, tokens[i+1].Pos, "Import "+path+" not found")
+		}
+		file = filepath.ToSlash(file)
+
+		if name, ok := l.loaded[file]; ok {
+			m.imports[path] = name
+			continue
+		}
+		name := l.moduleName(file)
+		l.loaded[file] = name
+		m.imports[path] = name
+
+		imported, err := l.add(name, file, *code)
+		if err != nil {
+			return err
+		}
+		err = l.resolve(imported)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// qualify prefixes the declarations of m with its name. Declarations of files included by m are left alone.
+func (m *module) qualify(nodes []*parser.Node) {
+	for _, node := range nodes {
+		if node.Type == parser.IMPORT {
+			i := node.Value.(parser.Import)
+			i.Module = m.imports[i.Path]
+			i.Importer = m.name
+			node.Value = i
+			continue
+		}
+		if m.name == "" || node.Pos >= m.own {
+			continue
+		}
+
+		switch node.Type {
+		case parser.FUNCTION:
+			f := node.Value.(parser.Function)
+			f.Name = m.name + "." + f.Name
+			node.Value = f
+		case parser.VARIABLE_DECLARATION:
+			v := node.Value.(parser.GlobalVariable)
+			v.Name = m.name + "." + v.Name
+			node.Value = v
+		case parser.OFFSET, parser.STRUCT_DECLARATION:
+			o := node.Value.(parser.Offset)
+			o.Name = m.name + "." + o.Name
+			node.Value = o
+		}
+	}
+}
+
+// Load preprocesses and parses the inputs and the modules imported by them. The inputs form one program without
+// a module name. Every file is parsed on its own, the declarations of all of them are returned in a single GLOBAL
+// node. Problems found in the source are reported to the returned reporter, whose code contains all modules.
+func Load(inputs []string, includes []string) (*parser.Node, *diagnostic.Reporter, error) {
+	_, global, reporter, err := load(inputs, includes)
+	return global, reporter, err
+}
+
+func load(inputs []string, includes []string) (*loader, *parser.Node, *diagnostic.Reporter, error) {
+	l := &loader{preprocessor: NewPreprocessor(includes), loaded: map[string]string{}}
+	for _, input := range inputs {
+		if _, ok := l.loaded[filepath.ToSlash(filepath.Clean(input))]; ok {
+			// already imported by an earlier input
+			continue
+		}
+		code, err := os.ReadFile(input)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		l.preprocessor.read[input] = contentHash(string(code))
+
+		root, err := l.add("", input, string(code))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		l.loaded[filepath.ToSlash(filepath.Clean(input))] = ""
+		err = l.resolve(root)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	combined := l.code.String()
+	reporter := diagnostic.NewReporter(combined)
+
+	tokens := [][]lexer.Token{}
+	// structs of included files and of the inputs are shared by all modules like their other declarations,
+	// the structs of modules are qualified with the module name
+	shared := []string{}
+	own := map[*module][]string{}
+	qualified := []string{}
+	for _, m := range l.modules {
+		lex := NewLexerAt(combined[:m.end], m.start, reporter)
+		tokens = append(tokens, lex.Tokenize())
+		t := tokens[len(tokens)-1]
+		for i := 0; i+1 < len(t); i++ {
+			if t[i].Type != lexer.ID || t[i].Value != "struct" || t[i+1].Type != lexer.ID {
+				continue
+			}
+			name := t[i+1].Value.(string)
+			if m.name != "" && t[i].Pos < m.own {
+				own[m] = append(own[m], name)
+				qualified = append(qualified, m.name+"."+name)
+			} else {
+				shared = append(shared, name)
+			}
+		}
+	}
+
+	global := []*parser.Node{}
+	for i, m := range l.modules {
+		p := NewParser(tokens[i], reporter)
+		p.structs = append(slices.Clone(shared), own[m]...)
+		p.structNames = map[string]string{}
+		for _, name := range own[m] {
+			p.structNames[name] = m.name + "." + name
+		}
+		p.modules = m.imports
+		p.moduleStructs = qualified
+		nodes := p.Global().Value.([]*parser.Node)
+		m.qualify(nodes)
+		global = append(global, nodes...)
+	}
+
+	return l, parser.NewNode(parser.GLOBAL, nil, nil, global), reporter, nil
+}
+
+// loadError converts an error of Load into diagnostics like Compile returns them.
+func loadError(err error) ([]diagnostic.Diagnostic, error) {
+	var preprocessorError PreprocessorError
+	if errors.As(err, &preprocessorError) {
+		return []diagnostic.Diagnostic{preprocessorError.Diagnostic()}, errors.New("preprocessing failed")
+	}
+	return nil, err
+}
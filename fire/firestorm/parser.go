@@ -1,39 +1,148 @@
 package firestorm
 
 import (
+	"fire/firestorm/constexpr"
+	"fire/firestorm/diagnostic"
 	"fire/firestorm/lexer"
 	"fire/firestorm/parser"
 	"fire/firestorm/utils"
-	"fmt"
 	"strconv"
-	"strings"
 )
 
 type Parser struct {
-	tokens  []lexer.Token
-	current *lexer.Token
-	pos     int
-	code    string
+	tokens   []lexer.Token
+	current  *lexer.Token
+	pos      int
+	reporter *diagnostic.Reporter
+	lastErr  int
+	structs  []string
+	// aliases of the imported modules, alias.name is read as a single identifier
+	imports []string
+	// set by Load: the qualified name of every struct declared by the parsed module, the module name of every
+	// import path and the qualified names of the structs of all modules
+	structNames   map[string]string
+	modules       map[string]string
+	moduleStructs []string
+	// module of every import alias
+	aliases map[string]string
 }
 
-func NewParser(tokens []lexer.Token, code string) Parser {
+// parseError is used to unwind the parser to the next recovery point after an error was reported.
+type parseError struct{}
+
+func NewParser(tokens []lexer.Token, reporter *diagnostic.Reporter) Parser {
 	p := Parser{
-		tokens:  tokens,
-		current: nil,
-		pos:     -1,
-		code:    code,
+		tokens:   tokens,
+		current:  nil,
+		pos:      -1,
+		reporter: reporter,
+		lastErr:  -1,
+		structs:  []string{},
+		imports:  []string{},
+		aliases:  map[string]string{},
 	}
+
+	// struct names are collected up front since includes are appended after the code using them
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].Type == lexer.ID && tokens[i].Value == "struct" && tokens[i+1].Type == lexer.ID {
+			p.structs = append(p.structs, tokens[i+1].Value.(string))
+		}
+	}
+
 	p.advance()
 	return p
 }
 
+func (p *Parser) isDatatype(name string) bool {
+	return parser.IsDatatypeString(name) || utils.IndexOf(p.structs, name) != -1 || name == "fn" || p.importedStruct() != ""
+}
+
+// importedStruct returns the qualified name of the struct of an imported module written as alias.name at the
+// current token, or "" if there is none.
+func (p *Parser) importedStruct() string {
+	module, ok := p.aliases[p.current.Value.(string)]
+	if !ok || p.pos+2 >= len(p.tokens) || p.tokens[p.pos+1].Type != lexer.DOT || p.tokens[p.pos+2].Type != lexer.ID {
+		return ""
+	}
+	name := module + "." + p.tokens[p.pos+2].Value.(string)
+	if utils.IndexOf(p.moduleStructs, name) == -1 {
+		return ""
+	}
+	return name
+}
+
+// datatype parses the datatype name without array suffix.
+func (p *Parser) datatype() parser.UnnamedDatatype {
+	name := p.current.Value.(string)
+	if qualified := p.importedStruct(); qualified != "" {
+		p.advance()
+		p.advance()
+		return parser.UnnamedDatatype{Type: parser.STRUCT, Name: qualified}
+	}
+	if utils.IndexOf(p.structs, name) != -1 {
+		if qualified, ok := p.structNames[name]; ok {
+			name = qualified
+		}
+		return parser.UnnamedDatatype{Type: parser.STRUCT, Name: name}
+	}
+	if name == "fn" {
+		return p.signature()
+	}
+
+	datatype, err := parser.GetDatatypeFromString(name)
+	if err != nil {
+		p.error(err.Error(), p.current.Pos)
+	}
+	return parser.UnnamedDatatype{Type: datatype}
+}
+
+// signature parses a function pointer type "fn(int, int) -> int" and stops at its last token like datatype.
+func (p *Parser) signature() parser.UnnamedDatatype {
+	p.advanceExpect(lexer.LPAREN)
+	p.advance()
+	arguments := []parser.UnnamedDatatype{}
+	for p.current.Type != lexer.RPAREN {
+		arguments = append(arguments, p.datatypeUnnamed())
+		if p.current.Type == lexer.COMMA {
+			p.advance()
+		} else {
+			p.expect(lexer.RPAREN)
+		}
+	}
+	p.advanceExpect(lexer.ARROW)
+	p.advance()
+	returnDatatype := p.datatypeUnnamed()
+	p.reverse()
+	return parser.UnnamedDatatype{Type: parser.FUNCTION_POINTER, Signature: &parser.Signature{Arguments: arguments, ReturnDatatype: returnDatatype}}
+}
+
 func (p *Parser) advance() {
 	p.pos++
-	if p.pos < len(p.tokens) {
-		p.current = &p.tokens[p.pos]
-	} else {
-		p.current = nil
+	if p.pos >= len(p.tokens) {
+		// stay on the END_OF_FILE token
+		p.pos = len(p.tokens) - 1
+	}
+	p.current = &p.tokens[p.pos]
+}
+
+// peek returns the token after the current one.
+func (p *Parser) peek() *lexer.Token {
+	if p.pos+1 < len(p.tokens) {
+		return &p.tokens[p.pos+1]
+	}
+	return p.current
+}
+
+// qualifiedName returns the identifier at the current token and stops at its last token. The name of an
+// imported module like sb.append is one identifier.
+func (p *Parser) qualifiedName() string {
+	name := p.current.Value.(string)
+	if utils.IndexOf(p.imports, name) != -1 && p.peek().Type == lexer.DOT {
+		p.advance()
+		p.advanceExpect(lexer.ID)
+		name += "." + p.current.Value.(string)
 	}
+	return name
 }
 
 func (p *Parser) reverse() {
@@ -42,17 +151,54 @@ func (p *Parser) reverse() {
 }
 
 func (p *Parser) error(message string, pos int) {
-	errorLine := parser.FindErrorLineFile(p.code, pos)
-	fmt.Println("error:", message, "(at", errorLine.File+":"+strconv.Itoa(errorLine.Line)+":"+strconv.Itoa(errorLine.Char)+")")
+	// avoid reporting follow up errors at the same location
+	if pos != p.lastErr {
+		p.reporter.Error(pos, message)
+		p.lastErr = pos
+	}
 
-	fmt.Println(strings.ReplaceAll(strings.ReplaceAll(errorLine.LineString, "\t", " "), "\r", " "))
+	panic(parseError{})
+}
 
-	for i := 0; i < errorLine.Char; i++ {
-		fmt.Print(" ")
-	}
-	fmt.Println("^")
+// recover catches a parseError raised by fn. It returns false if fn failed.
+func (p *Parser) recover(fn func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isParseError := r.(parseError); !isParseError {
+				panic(r)
+			}
+			ok = false
+		}
+	}()
+
+	fn()
+	return true
+}
 
-	panic("Parser failed")
+// synchronize skips tokens until the end of the broken statement (; or the } of a block opened after the error).
+// It returns false if it stopped at a } closing the surrounding block, which is not consumed.
+func (p *Parser) synchronize() bool {
+	depth := 0
+	for p.current.Type != lexer.END_OF_FILE {
+		switch p.current.Type {
+		case lexer.LBRACE:
+			depth++
+		case lexer.RBRACE:
+			if depth == 0 {
+				return false
+			}
+			depth--
+			if depth == 0 {
+				return true
+			}
+		case lexer.END_OF_LINE:
+			if depth == 0 {
+				return true
+			}
+		}
+		p.advance()
+	}
+	return false
 }
 
 func (p *Parser) expect(tokenType lexer.TokenType) {
@@ -81,31 +227,28 @@ func (p *Parser) commaOrRparen() bool {
 
 func (p *Parser) datatypeNamed() parser.NamedDatatype {
 	if p.current.Type == lexer.ID {
-		datatype, err := parser.GetDatatypeFromString(p.current.Value.(string))
-		if err != nil {
-			p.error(err.Error(), p.current.Pos)
-		}
+		datatype := p.datatype()
 		p.advance()
+		p.pointers(&datatype, true)
 		if p.current.Type == lexer.LBRACKET {
-			p.advanceExpect(lexer.RBRACKET)
+			p.advance()
+			if p.current.Type != lexer.RBRACKET {
+				datatype.Length = p.arrayLength()
+			}
+			p.expect(lexer.RBRACKET)
 			p.advanceExpect(lexer.ID)
+			datatype.IsArray = true
 			tmp := parser.NamedDatatype{
-				UnnamedDatatype: parser.UnnamedDatatype{
-					Type:    datatype,
-					IsArray: true,
-				},
-				Name: p.current.Value.(string),
+				UnnamedDatatype: datatype,
+				Name:            p.current.Value.(string),
 			}
 			p.advance()
 			return tmp
 		} else {
 			p.expect(lexer.ID)
 			tmp := parser.NamedDatatype{
-				UnnamedDatatype: parser.UnnamedDatatype{
-					Type:    datatype,
-					IsArray: false,
-				},
-				Name: p.current.Value.(string),
+				UnnamedDatatype: datatype,
+				Name:            p.current.Value.(string),
 			}
 			p.advance()
 			return tmp
@@ -116,26 +259,53 @@ func (p *Parser) datatypeNamed() parser.NamedDatatype {
 	panic("?")
 }
 
+// operandStarts contains the tokens which can start an operand.
+var operandStarts = []lexer.TokenType{lexer.NUMBER, lexer.FLOAT, lexer.CHARACTER, lexer.STRING, lexer.ID, lexer.LPAREN,
+	lexer.NOT, lexer.BIT_NOT, lexer.PLUS, lexer.MINUS, lexer.AND}
+
+// pointers parses the * suffixes of a typed pointer like int**. In declarations every * belongs to the datatype,
+// after "as" or sizeof a * followed by an operand is a multiplication like in "x as int * 2".
+func (p *Parser) pointers(datatype *parser.UnnamedDatatype, declaration bool) {
+	for p.current.Type == lexer.MULTIPLY {
+		if !declaration && utils.IndexOf(operandStarts, p.peek().Type) != -1 {
+			return
+		}
+		if datatype.Type == parser.VOID {
+			p.error("Pointers to void are not supported, use ptr", p.current.Pos)
+		}
+		datatype.Pointer++
+		p.advance()
+	}
+}
+
+// arrayLength parses the constant length of a fixed size array.
+func (p *Parser) arrayLength() int {
+	pos := p.current.Pos
+	expression := p.expression()
+	if expression == nil {
+		p.error("Expected array length", pos)
+	}
+	length, err := constexpr.Evaluate(expression)
+	if err != nil {
+		p.error(err.Error(), pos)
+	}
+	if length <= 0 {
+		p.error("Array length has to be positive but was "+strconv.Itoa(length), pos)
+	}
+	return length
+}
+
 func (p *Parser) datatypeUnnamed() parser.UnnamedDatatype {
 	if p.current.Type == lexer.ID {
-		datatype, err := parser.GetDatatypeFromString(p.current.Value.(string))
-		if err != nil {
-			p.error(err.Error(), p.current.Pos)
-		}
+		datatype := p.datatype()
 		p.advance()
+		p.pointers(&datatype, false)
 		if p.current.Type == lexer.LBRACKET {
 			p.advanceExpect(lexer.RBRACKET)
 			p.advance()
-			return parser.UnnamedDatatype{
-				Type:    datatype,
-				IsArray: true,
-			}
-		} else {
-			return parser.UnnamedDatatype{
-				Type:    datatype,
-				IsArray: false,
-			}
+			datatype.IsArray = true
 		}
+		return datatype
 	} else {
 		p.error("Expected id", p.current.Pos)
 	}
@@ -145,9 +315,6 @@ func (p *Parser) datatypeUnnamed() parser.UnnamedDatatype {
 
 func (p *Parser) factor() *parser.Node {
 	token := p.current
-	if token == nil {
-		return nil
-	}
 
 	if token.Type == lexer.LPAREN {
 		p.advance()
@@ -155,56 +322,68 @@ func (p *Parser) factor() *parser.Node {
 		result := p.expression()
 		p.expect(lexer.RPAREN)
 		p.advance()
-		return result
+		return p.fields(result)
 	} else if token.Type == lexer.NUMBER {
 		p.advance()
-		return parser.NewNode(parser.NUMBER, nil, nil, token.Value)
+		return parser.NewNodeAt(parser.NUMBER, nil, nil, token.Value, token.Pos)
+	} else if token.Type == lexer.FLOAT {
+		p.advance()
+		return parser.NewNodeAt(parser.FLOAT, nil, nil, token.Value, token.Pos)
+	} else if token.Type == lexer.CHARACTER {
+		p.advance()
+		return parser.NewNodeAt(parser.CHARACTER, nil, nil, token.Value, token.Pos)
 	} else if token.Type == lexer.STRING {
 		p.advance()
-		return parser.NewNode(parser.STRING, nil, nil, token.Value)
+		return parser.NewNodeAt(parser.STRING, nil, nil, token.Value, token.Pos)
 	} else if token.Type == lexer.NOT {
 		p.advance()
-		return parser.NewNode(parser.NOT, p.expression(), nil, token.Value)
+		return parser.NewNodeAt(parser.NOT, p.operand(), nil, token.Value, token.Pos)
 	} else if token.Type == lexer.BIT_NOT {
 		p.advance()
-		return parser.NewNode(parser.BIT_NOT, p.expression(), nil, token.Value)
+		return parser.NewNodeAt(parser.BIT_NOT, p.operand(), nil, token.Value, token.Pos)
+	} else if token.Type == lexer.MULTIPLY {
+		p.advance()
+		return parser.NewNodeAt(parser.DEREFERENCE, p.operand(), nil, nil, token.Pos)
+	} else if token.Type == lexer.AND {
+		p.advanceExpect(lexer.ID)
+		name := p.qualifiedName()
+		p.advance()
+		return parser.NewNodeAt(parser.ADDRESS_OF, nil, nil, name, token.Pos)
 	} else if token.Type == lexer.PLUS {
 		p.advance()
-		return parser.NewNode(parser.PLUS, p.factor(), nil, token.Value)
+		return parser.NewNodeAt(parser.PLUS, p.operand(), nil, token.Value, token.Pos)
 	} else if token.Type == lexer.MINUS {
 		p.advance()
-		return parser.NewNode(parser.MINUS, p.factor(), nil, token.Value)
+		return parser.NewNodeAt(parser.MINUS, p.operand(), nil, token.Value, token.Pos)
+	} else if token.Type == lexer.ID && token.Value == "asm" && p.peek().Type == lexer.LPAREN {
+		p.advance()
+		arguments := p.callArguments()
+		if len(arguments) == 0 || arguments[0].Type != parser.STRING {
+			p.error("Expected assembly code", token.Pos)
+		}
+		return parser.NewNodeAt(parser.INLINE_ASSEMBLY, nil, nil, parser.InlineAssembly{Code: arguments[0].Value.(string), Operands: arguments[1:]}, token.Pos)
+	} else if token.Type == lexer.ID && token.Value == "sizeof" {
+		p.advanceExpect(lexer.LPAREN)
+		p.advance()
+		datatype := p.datatypeUnnamed()
+		p.expect(lexer.RPAREN)
+		p.advance()
+		return parser.NewNodeAt(parser.SIZEOF, nil, nil, datatype, token.Pos)
 	} else if token.Type == lexer.ID {
+		name := p.qualifiedName()
 		p.advance()
 		if p.current.Type == lexer.LPAREN {
-			p.advance()
 			// function call
-			if p.current.Type == lexer.RPAREN {
-				p.advance()
-				return parser.NewNode(parser.FUNCTION_CALL, nil, nil, parser.FunctionCall{Name: token.Value.(string), Arguments: []*parser.Node{}})
-			} else {
-				arguments := []*parser.Node{}
-				for {
-					expression := p.expression()
-					if expression == nil {
-						p.error("Expected expression", p.current.Pos)
-						panic("?")
-					}
-					arguments = append(arguments, expression)
-					if p.commaOrRparen() {
-						return parser.NewNode(parser.FUNCTION_CALL, nil, nil, parser.FunctionCall{Name: token.Value.(string), Arguments: arguments})
-					}
-				}
-			}
+			return p.fields(parser.NewNodeAt(parser.FUNCTION_CALL, nil, nil, parser.FunctionCall{Name: name, Arguments: p.callArguments()}, token.Pos))
 		} else {
 			if p.current.Type == lexer.LBRACKET {
 				p.advance()
 				expression := p.expression()
 				p.expect(lexer.RBRACKET)
 				p.advance()
-				return parser.NewNode(parser.VARIABLE_LOOKUP_ARRAY, expression, nil, token.Value)
+				return p.fields(parser.NewNodeAt(parser.VARIABLE_LOOKUP_ARRAY, expression, nil, name, token.Pos))
 			} else {
-				return parser.NewNode(parser.VARIABLE_LOOKUP, nil, nil, token.Value)
+				return p.fields(parser.NewNodeAt(parser.VARIABLE_LOOKUP, nil, nil, name, token.Pos))
 			}
 		}
 	} else if token.Type == lexer.END_OF_LINE {
@@ -214,95 +393,139 @@ func (p *Parser) factor() *parser.Node {
 	panic("?")
 }
 
-func (p *Parser) bitLogic() *parser.Node {
-	result := p.factor()
+func (p *Parser) callArguments() []*parser.Node {
+	arguments := []*parser.Node{}
+	p.expect(lexer.LPAREN)
+	p.advance()
+	if p.current.Type == lexer.RPAREN {
+		p.advance()
+		return arguments
+	}
 
-	for p.current.Type == lexer.AND ||
-		p.current.Type == lexer.OR ||
-		p.current.Type == lexer.XOR ||
-		p.current.Type == lexer.SHIFT_LEFT ||
-		p.current.Type == lexer.SHIFT_RIGHT {
-		if p.current.Type == lexer.AND {
-			p.advance()
-			result = parser.NewNode(parser.AND, result, p.factor(), nil)
-		} else if p.current.Type == lexer.OR {
-			p.advance()
-			result = parser.NewNode(parser.OR, result, p.factor(), nil)
-		} else if p.current.Type == lexer.XOR {
-			p.advance()
-			result = parser.NewNode(parser.XOR, result, p.factor(), nil)
-		} else if p.current.Type == lexer.SHIFT_LEFT {
-			p.advance()
-			result = parser.NewNode(parser.SHIFT_LEFT, result, p.factor(), nil)
-		} else if p.current.Type == lexer.SHIFT_RIGHT {
-			p.advance()
-			result = parser.NewNode(parser.SHIFT_RIGHT, result, p.factor(), nil)
-		} else {
-			p.error("Invalid power", p.current.Pos)
+	for {
+		expression := p.expression()
+		if expression == nil {
+			p.error("Expected expression", p.current.Pos)
+		}
+		arguments = append(arguments, expression)
+		if p.commaOrRparen() {
+			return arguments
 		}
 	}
-
-	return result
 }
 
-func (p *Parser) term() *parser.Node {
-	result := p.bitLogic()
-
-	for p.current.Type == lexer.MULTIPLY ||
-		p.current.Type == lexer.DIVIDE ||
-		p.current.Type == lexer.MODULO {
-
-		if p.current.Type == lexer.MULTIPLY {
-			p.advance()
-			result = parser.NewNode(parser.MULTIPLY, result, p.bitLogic(), nil)
-		} else if p.current.Type == lexer.DIVIDE {
-			p.advance()
-			result = parser.NewNode(parser.DIVIDE, result, p.bitLogic(), nil)
-		} else if p.current.Type == lexer.MODULO {
-			p.advance()
-			result = parser.NewNode(parser.MODULO, result, p.bitLogic(), nil)
-		} else {
-			p.error("Invalid term", p.current.Pos)
+// fields parses a chain of .field accesses on top of node.
+func (p *Parser) fields(node *parser.Node) *parser.Node {
+	for p.current.Type == lexer.DOT {
+		pos := p.current.Pos
+		p.advanceExpect(lexer.ID)
+		node = parser.NewNodeAt(parser.FIELD_LOOKUP, node, nil, p.current.Value.(string), pos)
+		p.advance()
+		if p.current.Type == lexer.LPAREN {
+			// call through a function pointer field
+			node = parser.NewNodeAt(parser.FUNCTION_CALL, nil, nil, parser.FunctionCall{Name: node.Value.(string), Arguments: p.callArguments(), Callee: node}, pos)
 		}
 	}
+	return node
+}
 
+// operand parses the operand of an unary operator.
+func (p *Parser) operand() *parser.Node {
+	result := p.factor()
+	if result == nil {
+		p.error("Expected expression", p.current.Pos)
+	}
 	return result
 }
 
-func (p *Parser) compare() *parser.Node {
-	result := p.term()
+// cast parses a factor followed by any number of "as <datatype>" conversions.
+func (p *Parser) cast() *parser.Node {
+	result := p.factor()
 
-	for p.current.Type == lexer.EQUALS ||
-		p.current.Type == lexer.NOT_EQUALS ||
-		p.current.Type == lexer.LESS ||
-		p.current.Type == lexer.LESS_EQUALS ||
-		p.current.Type == lexer.MORE ||
-		p.current.Type == lexer.MORE_EQUALS {
-		compare, _ := parser.TokenTypeToCompare(p.current.Type)
+	for p.current.Type == lexer.ID && p.current.Value == "as" {
+		pos := p.current.Pos
 		p.advance()
-		result = parser.NewNode(parser.COMPARE, result, p.term(), compare)
+		result = parser.NewNodeAt(parser.CAST, result, nil, p.datatypeUnnamed(), pos)
 	}
 
 	return result
 }
 
+// Binary operators from loosest to tightest binding. All of them are left associative.
+// Unary operators (! ~ + - * &) bind tighter than any binary operator, followed by "as" casts.
+//
+//	1   ||
+//	2   &&
+//	3   |
+//	4   ^
+//	5   &
+//	6   == !=
+//	7   < <= > >=
+//	8   << >>
+//	9   + -
+//	10  * / %
+var precedences = map[lexer.TokenType]int{
+	lexer.LOGICAL_OR:  1,
+	lexer.LOGICAL_AND: 2,
+	lexer.OR:          3,
+	lexer.XOR:         4,
+	lexer.AND:         5,
+	lexer.EQUALS:      6,
+	lexer.NOT_EQUALS:  6,
+	lexer.LESS:        7,
+	lexer.LESS_EQUALS: 7,
+	lexer.MORE:        7,
+	lexer.MORE_EQUALS: 7,
+	lexer.SHIFT_LEFT:  8,
+	lexer.SHIFT_RIGHT: 8,
+	lexer.PLUS:        9,
+	lexer.MINUS:       9,
+	lexer.MULTIPLY:    10,
+	lexer.DIVIDE:      10,
+	lexer.MODULO:      10,
+}
+
+var binaryNodes = map[lexer.TokenType]parser.NodeType{
+	lexer.LOGICAL_OR:  parser.LOGICAL_OR,
+	lexer.LOGICAL_AND: parser.LOGICAL_AND,
+	lexer.OR:          parser.OR,
+	lexer.XOR:         parser.XOR,
+	lexer.AND:         parser.AND,
+	lexer.SHIFT_LEFT:  parser.SHIFT_LEFT,
+	lexer.SHIFT_RIGHT: parser.SHIFT_RIGHT,
+	lexer.PLUS:        parser.ADD,
+	lexer.MINUS:       parser.SUBTRACT,
+	lexer.MULTIPLY:    parser.MULTIPLY,
+	lexer.DIVIDE:      parser.DIVIDE,
+	lexer.MODULO:      parser.MODULO,
+}
+
 func (p *Parser) expression() *parser.Node {
-	result := p.compare()
+	return p.binary(1)
+}
 
-	for p.current.Type == lexer.PLUS ||
-		p.current.Type == lexer.MINUS {
-		if p.current.Type == lexer.MINUS {
-			p.advance()
-			result = parser.NewNode(parser.SUBTRACT, result, p.term(), nil)
-		} else if p.current.Type == lexer.PLUS {
-			p.advance()
-			result = parser.NewNode(parser.ADD, result, p.term(), nil)
+// binary parses operators with at least minPrecedence using precedence climbing.
+func (p *Parser) binary(minPrecedence int) *parser.Node {
+	result := p.cast()
+	for {
+		precedence, ok := precedences[p.current.Type]
+		if !ok || precedence < minPrecedence || result == nil {
+			return result
+		}
+
+		token := p.current
+		p.advance()
+		right := p.binary(precedence + 1)
+		if right == nil {
+			p.error("Expected expression", p.current.Pos)
+		}
+
+		if compare, err := parser.TokenTypeToCompare(token.Type); err == nil {
+			result = parser.NewNodeAt(parser.COMPARE, result, right, compare, token.Pos)
 		} else {
-			p.error("Invalid expression", p.current.Pos)
+			result = parser.NewNodeAt(binaryNodes[token.Type], result, right, nil, token.Pos)
 		}
 	}
-
-	return result
 }
 
 func (p *Parser) functionAttributes() []parser.FunctionAttribute {
@@ -312,7 +535,12 @@ func (p *Parser) functionAttributes() []parser.FunctionAttribute {
 		p.advance()
 		for {
 			if p.current.Type == lexer.ID {
-				attributes = append(attributes, parser.StringToFunctionAttribute(p.current.Value.(string)))
+				if attribute, ok := parser.StringToFunctionAttribute(p.current.Value.(string)); ok {
+					attributes = append(attributes, attribute)
+				} else {
+					// the attribute is skipped, the rest of the function can still be checked
+					p.reporter.Error(p.current.Pos, "Unknown function attribute "+p.current.Value.(string))
+				}
 				p.advance()
 				if p.commaOrRparen() {
 					return attributes
@@ -326,23 +554,30 @@ func (p *Parser) functionAttributes() []parser.FunctionAttribute {
 	}
 }
 
-func (p *Parser) functionArguments() []parser.NamedDatatype {
-	arguments := []parser.NamedDatatype{}
+// functionArguments parses the argument list, variadic is true if it ends with "...".
+func (p *Parser) functionArguments() (arguments []parser.NamedDatatype, variadic bool) {
+	arguments = []parser.NamedDatatype{}
 	p.expect(lexer.LPAREN)
 	p.advance()
 	if p.current.Type == lexer.RPAREN {
 		p.advance()
-		return arguments
+		return arguments, false
 	}
 	for {
+		if p.current.Type == lexer.ELLIPSIS {
+			p.advanceExpect(lexer.RPAREN)
+			p.advance()
+			return arguments, true
+		}
 		arguments = append(arguments, p.datatypeNamed())
 		if p.commaOrRparen() {
-			return arguments
+			return arguments, false
 		}
 	}
 }
 
 func (p *Parser) parseIf() *parser.Node {
+	pos := p.current.Pos
 	p.advance()
 	expression := p.expression()
 	if expression == nil {
@@ -359,7 +594,7 @@ func (p *Parser) parseIf() *parser.Node {
 				if p.current.Value == "if" {
 					elseCodeBlock := p.parseIf()
 					p.expect(lexer.RBRACE)
-					return parser.NewNode(parser.IF, expression, nil, parser.If{TrueBlock: codeBlock, FalseBlock: []*parser.Node{elseCodeBlock}})
+					return parser.NewNodeAt(parser.IF, expression, nil, parser.If{TrueBlock: codeBlock, FalseBlock: []*parser.Node{elseCodeBlock}}, pos)
 				} else {
 					p.error("Expected if", p.current.Pos)
 					panic("?")
@@ -368,32 +603,78 @@ func (p *Parser) parseIf() *parser.Node {
 				p.expect(lexer.LBRACE)
 				elseCodeBlock := p.codeBlock()
 				p.expect(lexer.RBRACE)
-				return parser.NewNode(parser.IF, expression, nil, parser.If{TrueBlock: codeBlock, FalseBlock: elseCodeBlock})
+				return parser.NewNodeAt(parser.IF, expression, nil, parser.If{TrueBlock: codeBlock, FalseBlock: elseCodeBlock}, pos)
 			}
 		} else {
 			p.reverse()
-			return parser.NewNode(parser.IF, expression, nil, parser.If{TrueBlock: codeBlock, FalseBlock: []*parser.Node{}})
+			return parser.NewNodeAt(parser.IF, expression, nil, parser.If{TrueBlock: codeBlock, FalseBlock: []*parser.Node{}}, pos)
 		}
 	} else {
 		p.reverse()
-		return parser.NewNode(parser.IF, expression, nil, parser.If{TrueBlock: codeBlock, FalseBlock: []*parser.Node{}})
+		return parser.NewNodeAt(parser.IF, expression, nil, parser.If{TrueBlock: codeBlock, FalseBlock: []*parser.Node{}}, pos)
+	}
+}
+
+// parseSwitch parses "switch expression { case 1, 2 { } default { } }".
+func (p *Parser) parseSwitch() *parser.Node {
+	pos := p.current.Pos
+	p.advance()
+	expression := p.expression()
+	if expression == nil {
+		p.error("Expected expression", p.current.Pos)
+	}
+	p.expect(lexer.LBRACE)
+	p.advance()
+
+	s := parser.Switch{Cases: []parser.Case{}}
+	for p.current.Type != lexer.RBRACE {
+		if p.current.Type != lexer.ID {
+			p.error("Expected case or default", p.current.Pos)
+		}
+		switch p.current.Value {
+		case "case":
+			values := []*parser.Node{}
+			for {
+				p.advance()
+				value := p.expression()
+				if value == nil {
+					p.error("Expected expression", p.current.Pos)
+				}
+				values = append(values, value)
+				if p.current.Type != lexer.COMMA {
+					break
+				}
+			}
+			s.Cases = append(s.Cases, parser.Case{Values: values, Body: p.codeBlock()})
+		case "default":
+			if s.Default != nil {
+				p.error("Duplicate default in switch", p.current.Pos)
+			}
+			p.advance()
+			s.Default = p.codeBlock()
+		default:
+			p.error("Expected case or default", p.current.Pos)
+		}
+		p.expect(lexer.RBRACE)
+		p.advance()
 	}
+	return parser.NewNodeAt(parser.SWITCH, expression, nil, s, pos)
 }
 
 func (p *Parser) keyword() []*parser.Node {
 	if p.current.Type != lexer.ID {
 		return nil
 	}
+	pos := p.current.Pos
 	switch p.current.Value.(string) {
 	case "return":
 		p.advance()
-		ret := []*parser.Node{parser.NewNode(parser.RETURN, p.expression(), nil, nil)}
+		ret := []*parser.Node{parser.NewNodeAt(parser.RETURN, p.expression(), nil, nil, pos)}
 		p.expect(lexer.END_OF_LINE)
 		return ret
 	case "for":
-		forBody := []*parser.Node{}
 		p.advance()
-		forBody = append(forBody, p.codeLine())
+		init := p.codeLine()
 		p.expect(lexer.END_OF_LINE)
 		p.advance()
 
@@ -406,13 +687,13 @@ func (p *Parser) keyword() []*parser.Node {
 		}
 		update := p.codeLine()
 		codeBlock := p.codeBlock()
-		codeBlock = append(codeBlock, update)
-		forBody = append(forBody, parser.NewNode(parser.CONDITIONAL_LOOP, expression, nil, codeBlock))
 		p.expect(lexer.RBRACE)
 
-		return forBody
+		return []*parser.Node{parser.NewNodeAt(parser.CONDITIONAL_LOOP, expression, nil, parser.Loop{Body: codeBlock, Init: init, Update: update}, pos)}
 	case "if":
 		return []*parser.Node{p.parseIf()}
+	case "switch":
+		return []*parser.Node{p.parseSwitch()}
 	case "while":
 		p.advance()
 		expression := p.expression()
@@ -423,7 +704,7 @@ func (p *Parser) keyword() []*parser.Node {
 
 		codeBlock := p.codeBlock()
 		p.expect(lexer.RBRACE)
-		return []*parser.Node{parser.NewNode(parser.CONDITIONAL_LOOP, expression, nil, codeBlock)}
+		return []*parser.Node{parser.NewNodeAt(parser.CONDITIONAL_LOOP, expression, nil, parser.Loop{Body: codeBlock}, pos)}
 	case "do":
 		p.advanceExpect(lexer.LBRACE)
 		codeBlock := p.codeBlock()
@@ -438,19 +719,32 @@ func (p *Parser) keyword() []*parser.Node {
 			p.error("Expected expression", p.current.Pos)
 		}
 		p.expect(lexer.END_OF_LINE)
-		return []*parser.Node{parser.NewNode(parser.POST_CONDITIONAL_LOOP, expression, nil, codeBlock)}
+		return []*parser.Node{parser.NewNodeAt(parser.POST_CONDITIONAL_LOOP, expression, nil, parser.Loop{Body: codeBlock}, pos)}
 	case "loop":
 		p.advance()
 		p.expect(lexer.LBRACE)
 		codeBlock := p.codeBlock()
 		p.expect(lexer.RBRACE)
-		return []*parser.Node{parser.NewNode(parser.LOOP, nil, nil, codeBlock)}
+		return []*parser.Node{parser.NewNodeAt(parser.LOOP, nil, nil, parser.Loop{Body: codeBlock}, pos)}
+	case "break", "continue":
+		nodeType := parser.BREAK
+		if p.current.Value == "continue" {
+			nodeType = parser.CONTINUE
+		}
+		label := ""
+		p.advance()
+		if p.current.Type == lexer.ID {
+			label = p.current.Value.(string)
+			p.advance()
+		}
+		p.expect(lexer.END_OF_LINE)
+		return []*parser.Node{parser.NewNodeAt(nodeType, nil, nil, label, pos)}
 	case "end":
 		p.advance()
 		p.expect(lexer.LBRACE)
 		codeBlock := p.codeBlock()
 		p.expect(lexer.RBRACE)
-		return []*parser.Node{parser.NewNode(parser.END_EXEC, nil, nil, codeBlock)}
+		return []*parser.Node{parser.NewNodeAt(parser.END_EXEC, nil, nil, codeBlock, pos)}
 	default:
 		return nil
 	}
@@ -458,16 +752,18 @@ func (p *Parser) keyword() []*parser.Node {
 
 func (p *Parser) codeLine() *parser.Node {
 	if p.current.Type == lexer.ID {
-		if parser.IsDatatypeString(p.current.Value.(string)) {
+		pos := p.current.Pos
+		if p.isDatatype(p.current.Value.(string)) {
 			datatype := p.datatypeNamed()
 			if p.current.Type == lexer.END_OF_LINE {
-				return parser.NewNode(parser.VARIABLE_DECLARATION, nil, nil, datatype)
+				return parser.NewNodeAt(parser.VARIABLE_DECLARATION, nil, nil, datatype, pos)
 			}
 			p.expect(lexer.ASSIGN)
 			p.advance()
-			return parser.NewNode(parser.VARIABLE_DECLARATION, p.expression(), nil, datatype)
+			return parser.NewNodeAt(parser.VARIABLE_DECLARATION, p.initializer(), nil, datatype, pos)
 		} else {
-			possibleVariableName := p.current.Value.(string)
+			start := p.pos
+			possibleVariableName := p.qualifiedName()
 			p.advance()
 			if p.current.Type == lexer.ASSIGN {
 				p.advance()
@@ -475,42 +771,122 @@ func (p *Parser) codeLine() *parser.Node {
 				if expression == nil {
 					p.error("Expected expression", p.current.Pos)
 				}
-				return parser.NewNode(parser.VARIABLE_ASSIGN, expression, nil, possibleVariableName)
+				return parser.NewNodeAt(parser.VARIABLE_ASSIGN, expression, nil, possibleVariableName, pos)
 			} else if p.current.Type == lexer.INCREASE {
 				p.advance()
-				return parser.NewNode(parser.VARIABLE_INCREASE, nil, nil, possibleVariableName)
+				return parser.NewNodeAt(parser.VARIABLE_INCREASE, nil, nil, possibleVariableName, pos)
 			} else if p.current.Type == lexer.DECREASE {
 				p.advance()
-				return parser.NewNode(parser.VARIABLE_DECREASE, nil, nil, possibleVariableName)
-			} else if p.current.Type == lexer.LBRACKET {
-				p.advance()
-				indexExpression := p.expression()
-				if indexExpression == nil {
-					p.error("Expected expression", p.current.Pos)
+				return parser.NewNodeAt(parser.VARIABLE_DECREASE, nil, nil, possibleVariableName, pos)
+			} else {
+				for p.pos > start {
+					p.reverse()
 				}
-				p.expect(lexer.RBRACKET)
-				p.advanceExpect(lexer.ASSIGN)
-				p.advance()
 				expression := p.expression()
 				if expression == nil {
 					p.error("Expected expression", p.current.Pos)
 				}
-				return parser.NewNode(parser.VARIABLE_ASSIGN_ARRAY, indexExpression, expression, possibleVariableName)
-			} else {
-				p.reverse()
-				expression := p.expression()
-				if expression == nil {
-					p.error("Expected expression", p.current.Pos)
+				if p.current.Type == lexer.ASSIGN {
+					return p.assignTarget(expression)
 				}
 				return expression
 			}
 		}
+	} else if p.current.Type == lexer.MULTIPLY {
+		// write through a pointer like *p = 5
+		expression := p.expression()
+		if p.current.Type == lexer.ASSIGN {
+			return p.assignTarget(expression)
+		}
+		return expression
 	} else {
 		p.error("Expected id", p.current.Pos)
 	}
 	panic("?")
 }
 
+// initializer parses the value of a variable declaration, which may be an array literal like {1, 2, 3}.
+func (p *Parser) initializer() *parser.Node {
+	if p.current.Type != lexer.LBRACE {
+		return p.expression()
+	}
+
+	pos := p.current.Pos
+	elements := []*parser.Node{}
+	p.advance()
+	for p.current.Type != lexer.RBRACE {
+		element := p.expression()
+		if element == nil {
+			p.error("Expected expression", p.current.Pos)
+		}
+		elements = append(elements, element)
+		if p.current.Type == lexer.COMMA {
+			p.advance()
+		} else {
+			p.expect(lexer.RBRACE)
+		}
+	}
+	p.advance()
+	return parser.NewNodeAt(parser.ARRAY_LITERAL, nil, nil, elements, pos)
+}
+
+// assignTarget parses the value assigned to an array element, a struct field or through a pointer.
+func (p *Parser) assignTarget(target *parser.Node) *parser.Node {
+	pos := p.current.Pos
+	p.advance()
+	expression := p.expression()
+	if expression == nil {
+		p.error("Expected expression", p.current.Pos)
+	}
+
+	switch target.Type {
+	case parser.VARIABLE_LOOKUP_ARRAY:
+		return parser.NewNodeAt(parser.VARIABLE_ASSIGN_ARRAY, target.A, expression, target.Value, target.Pos)
+	case parser.FIELD_LOOKUP:
+		return parser.NewNodeAt(parser.FIELD_ASSIGN, target, expression, nil, target.Pos)
+	case parser.DEREFERENCE:
+		return parser.NewNodeAt(parser.DEREFERENCE_ASSIGN, target, expression, nil, target.Pos)
+	default:
+		p.error("Invalid assignment target", pos)
+		panic("?")
+	}
+}
+
+// labeledLoop parses "label: <loop>" and attaches the label to the loop.
+func (p *Parser) labeledLoop() []*parser.Node {
+	label := p.current.Value.(string)
+	pos := p.current.Pos
+	p.advance()
+	p.advance()
+
+	nodes := p.keyword()
+	if len(nodes) > 0 {
+		loop := nodes[len(nodes)-1]
+		if l, ok := loop.Value.(parser.Loop); ok {
+			l.Label = label
+			loop.Value = l
+			return nodes
+		}
+	}
+	p.error("Expected loop after label "+label, pos)
+	panic("?")
+}
+
+func (p *Parser) statement() []*parser.Node {
+	if p.current.Type == lexer.ID && p.peek().Type == lexer.COLON {
+		return p.labeledLoop()
+	}
+
+	keyword := p.keyword()
+	if keyword != nil {
+		return keyword
+	} else {
+		line := p.codeLine()
+		p.expect(lexer.END_OF_LINE)
+		return []*parser.Node{line}
+	}
+}
+
 func (p *Parser) codeBlock() []*parser.Node {
 	body := []*parser.Node{}
 	p.expect(lexer.LBRACE)
@@ -519,12 +895,15 @@ func (p *Parser) codeBlock() []*parser.Node {
 		if p.current.Type == lexer.RBRACE {
 			return body
 		}
-		keyword := p.keyword()
-		if keyword != nil {
-			body = append(body, keyword...)
-		} else {
-			body = append(body, p.codeLine())
-			p.expect(lexer.END_OF_LINE)
+		if p.current.Type == lexer.END_OF_FILE {
+			p.error("Expected } but was end of file", p.current.Pos)
+		}
+
+		ok := p.recover(func() {
+			body = append(body, p.statement()...)
+		})
+		if !ok && !p.synchronize() {
+			continue
 		}
 		p.advance()
 	}
@@ -533,89 +912,150 @@ func (p *Parser) codeBlock() []*parser.Node {
 func (p *Parser) Global() *parser.Node {
 	global := []*parser.Node{}
 
-	for p.current != nil {
-		if p.current.Type == lexer.ID {
-			if parser.IsDatatypeString(p.current.Value.(string)) {
-				datatype := p.datatypeNamed()
-				if p.current.Type == lexer.END_OF_LINE {
-					global = append(global, parser.NewNode(parser.VARIABLE_DECLARATION, nil, nil, datatype))
-				} else {
-					p.expect(lexer.ASSIGN)
-					p.advance()
-					global = append(global, parser.NewNode(parser.VARIABLE_DECLARATION, p.expression(), nil, datatype))
-					p.expect(lexer.END_OF_LINE)
-				}
-			} else if p.current.Value == "function" {
-				p.advance()
-
-				attributes := p.functionAttributes()
-				p.expect(lexer.ID)
-				name := p.current.Value.(string)
-				p.advance()
-				arguments := p.functionArguments()
-				p.expect(lexer.ARROW)
-				p.advance()
-				returnDatatype := p.datatypeUnnamed()
-				if utils.IndexOf(attributes, parser.Assembly) >= 0 {
-					p.expect(lexer.LBRACE)
-					p.advanceExpect(lexer.STRING)
-					body := []*parser.Node{parser.NewNode(parser.ASSEMBLY_CODE, nil, nil, p.current.Value)}
-					p.advanceExpect(lexer.RBRACE)
-					global = append(global, parser.NewNode(parser.FUNCTION, nil, nil, parser.Function{
-						Name:           name,
-						Attributes:     attributes,
-						Body:           body,
-						ReturnDatatype: returnDatatype,
-						Arguments:      arguments,
-					}))
-				} else if utils.IndexOf(attributes, parser.External) >= 0 {
-					p.expect(lexer.END_OF_LINE)
-					global = append(global, parser.NewNode(parser.FUNCTION, nil, nil, parser.Function{
-						Name:           name,
-						Attributes:     attributes,
-						Body:           nil,
-						ReturnDatatype: returnDatatype,
-						Arguments:      arguments,
-					}))
-				} else {
-					codeBlock := p.codeBlock()
-					global = append(global, parser.NewNode(parser.FUNCTION, nil, nil, parser.Function{
-						Name:           name,
-						Attributes:     attributes,
-						Body:           codeBlock,
-						ReturnDatatype: returnDatatype,
-						Arguments:      arguments,
-					}))
-				}
-			} else if p.current.Value == "offset" {
-				p.advanceExpect(lexer.ID)
-				name := p.current.Value.(string)
-				p.advanceExpect(lexer.LBRACE)
-
-				entries := []parser.NamedDatatype{}
-
-				for {
-					p.advance()
-					if p.current.Type == lexer.RBRACE {
-						break
-					}
-					entries = append(entries, p.datatypeNamed())
-					p.expect(lexer.END_OF_LINE)
-				}
-
-				p.expect(lexer.RBRACE)
-				global = append(global, parser.NewNode(parser.OFFSET, nil, nil, parser.Offset{
-					Name:    name,
-					Entries: entries,
-				}))
-			} else {
-				p.error("Expected function", p.pos)
+	for p.current.Type != lexer.END_OF_FILE {
+		ok := p.recover(func() {
+			global = append(global, p.declaration())
+		})
+		if !ok {
+			if !p.synchronize() && p.current.Type == lexer.END_OF_FILE {
+				break
 			}
-		} else {
-			p.error("Expected id", p.current.Pos)
 		}
 		p.advance()
 	}
 
 	return parser.NewNode(parser.GLOBAL, nil, nil, global)
 }
+
+// offset parses the name and entries of an offset or struct declaration documented by doc.
+func (p *Parser) offset(doc string) parser.Offset {
+	p.advanceExpect(lexer.ID)
+	name := p.current.Value.(string)
+	p.advanceExpect(lexer.LBRACE)
+
+	entries := []parser.NamedDatatype{}
+
+	for {
+		p.advance()
+		if p.current.Type == lexer.RBRACE {
+			break
+		}
+		entries = append(entries, p.datatypeNamed())
+		p.expect(lexer.END_OF_LINE)
+	}
+
+	p.expect(lexer.RBRACE)
+	return parser.Offset{
+		Name:    name,
+		Entries: entries,
+		Doc:     doc,
+	}
+}
+
+// importDeclaration parses import "path" as alias.
+func (p *Parser) importDeclaration() parser.Import {
+	p.advanceExpect(lexer.STRING)
+	path := p.current.Value.(string)
+	p.advanceExpect(lexer.ID)
+	if p.current.Value != "as" {
+		p.error("Expected as after import path", p.current.Pos)
+	}
+	p.advanceExpect(lexer.ID)
+	alias := p.current.Value.(string)
+	if utils.IndexOf(p.imports, alias) != -1 {
+		p.error("Duplicate import alias "+alias, p.current.Pos)
+	}
+	p.imports = append(p.imports, alias)
+	p.aliases[alias] = p.modules[path]
+	p.advanceExpect(lexer.END_OF_LINE)
+	return parser.Import{Path: path, Alias: alias}
+}
+
+func (p *Parser) declaration() *parser.Node {
+	if p.current.Type != lexer.ID {
+		p.error("Expected id", p.current.Pos)
+	}
+
+	pos := p.current.Pos
+	doc := p.current.Doc
+	attributes := []parser.FunctionAttribute{}
+	for p.current.Value == "global" || p.current.Value == "keep" {
+		attribute, _ := parser.StringToFunctionAttribute(p.current.Value.(string))
+		attributes = append(attributes, attribute)
+		p.advanceExpect(lexer.ID)
+		if !p.isDatatype(p.current.Value.(string)) && p.current.Value != "global" && p.current.Value != "keep" {
+			p.error("Expected variable declaration", p.current.Pos)
+		}
+	}
+
+	if p.isDatatype(p.current.Value.(string)) {
+		variable := parser.GlobalVariable{NamedDatatype: p.datatypeNamed(), Attributes: attributes, Doc: doc}
+		if p.current.Type == lexer.END_OF_LINE {
+			return parser.NewNodeAt(parser.VARIABLE_DECLARATION, nil, nil, variable, pos)
+		}
+		p.expect(lexer.ASSIGN)
+		p.advance()
+		declaration := parser.NewNodeAt(parser.VARIABLE_DECLARATION, p.initializer(), nil, variable, pos)
+		p.expect(lexer.END_OF_LINE)
+		return declaration
+	} else if p.current.Value == "function" {
+		p.advance()
+
+		attributes := p.functionAttributes()
+		p.expect(lexer.ID)
+		name := p.current.Value.(string)
+		p.advance()
+		argumentsPos := p.current.Pos
+		arguments, variadic := p.functionArguments()
+		if variadic && utils.IndexOf(attributes, parser.External) == -1 {
+			p.error("Only external functions can be variadic", argumentsPos)
+		}
+		p.expect(lexer.ARROW)
+		p.advance()
+		returnDatatype := p.datatypeUnnamed()
+		if utils.IndexOf(attributes, parser.Assembly) >= 0 {
+			p.expect(lexer.LBRACE)
+			p.advanceExpect(lexer.STRING)
+			body := []*parser.Node{parser.NewNodeAt(parser.ASSEMBLY_CODE, nil, nil, p.current.Value, p.current.Pos)}
+			p.advanceExpect(lexer.RBRACE)
+			return parser.NewNodeAt(parser.FUNCTION, nil, nil, parser.Function{
+				Name:           name,
+				Attributes:     attributes,
+				Body:           body,
+				ReturnDatatype: returnDatatype,
+				Arguments:      arguments,
+				Doc:            doc,
+			}, pos)
+		} else if utils.IndexOf(attributes, parser.External) >= 0 {
+			p.expect(lexer.END_OF_LINE)
+			return parser.NewNodeAt(parser.FUNCTION, nil, nil, parser.Function{
+				Name:           name,
+				Attributes:     attributes,
+				Body:           nil,
+				ReturnDatatype: returnDatatype,
+				Arguments:      arguments,
+				Variadic:       variadic,
+				Doc:            doc,
+			}, pos)
+		} else {
+			codeBlock := p.codeBlock()
+			return parser.NewNodeAt(parser.FUNCTION, nil, nil, parser.Function{
+				Name:           name,
+				Attributes:     attributes,
+				Body:           codeBlock,
+				ReturnDatatype: returnDatatype,
+				Arguments:      arguments,
+				Doc:            doc,
+			}, pos)
+		}
+	} else if p.current.Value == "import" && len(attributes) == 0 {
+		return parser.NewNodeAt(parser.IMPORT, nil, nil, p.importDeclaration(), pos)
+	} else if p.current.Value == "offset" {
+		return parser.NewNodeAt(parser.OFFSET, nil, nil, p.offset(doc), pos)
+	} else if p.current.Value == "struct" {
+		return parser.NewNodeAt(parser.STRUCT_DECLARATION, nil, nil, p.offset(doc), pos)
+	}
+
+	p.error("Expected function", p.current.Pos)
+	panic("?")
+}
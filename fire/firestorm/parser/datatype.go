@@ -3,6 +3,8 @@ package parser
 import (
 	"fire/firestorm/lexer"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 type DataType int
@@ -16,6 +18,16 @@ const (
 	PTR
 	INT_32
 	INT_16
+	STRUCT
+	FLOAT_32
+	FLOAT_64
+	INT_8
+	INT_64
+	UINT_8
+	UINT_16
+	UINT_32
+	UINT_64
+	FUNCTION_POINTER
 )
 
 func GetDatatypeFromString(t string) (DataType, error) {
@@ -34,13 +46,30 @@ func GetDatatypeFromString(t string) (DataType, error) {
 		return INT_32, nil
 	case "i16":
 		return INT_16, nil
+	case "f32":
+		return FLOAT_32, nil
+	case "f64":
+		return FLOAT_64, nil
+	case "i8":
+		return INT_8, nil
+	case "i64":
+		return INT_64, nil
+	case "u8":
+		return UINT_8, nil
+	case "u16":
+		return UINT_16, nil
+	case "u32":
+		return UINT_32, nil
+	case "u64":
+		return UINT_64, nil
 	default:
 		return INVALID, fmt.Errorf("Invalid datatype " + t)
 	}
 }
 
 func IsDatatypeString(t string) bool {
-	return t == "int" || t == "str" || t == "void" || t == "chr" || t == "ptr" || t == "i32" || t == "i16"
+	_, err := GetDatatypeFromString(t)
+	return err == nil
 }
 
 func GetTokenFromDatatype(d DataType) lexer.TokenType {
@@ -54,9 +83,125 @@ func GetTokenFromDatatype(d DataType) lexer.TokenType {
 	}
 }
 
+func GetStringFromDatatype(d DataType) string {
+	switch d {
+	case INT:
+		return "int"
+	case STR:
+		return "str"
+	case VOID:
+		return "void"
+	case CHR:
+		return "chr"
+	case PTR:
+		return "ptr"
+	case INT_32:
+		return "i32"
+	case INT_16:
+		return "i16"
+	case FLOAT_32:
+		return "f32"
+	case FLOAT_64:
+		return "f64"
+	case INT_8:
+		return "i8"
+	case INT_64:
+		return "i64"
+	case UINT_8:
+		return "u8"
+	case UINT_16:
+		return "u16"
+	case UINT_32:
+		return "u32"
+	case UINT_64:
+		return "u64"
+	case FUNCTION_POINTER:
+		return "fn"
+	default:
+		return "<invalid>"
+	}
+}
+
 type UnnamedDatatype struct {
 	Type    DataType
 	IsArray bool
+	// Name of the struct if Type is STRUCT
+	Name string
+	// Signature of the function if Type is FUNCTION_POINTER
+	Signature *Signature
+	// Length of a fixed size stack array like int[16], 0 for array pointers
+	Length int
+	// Pointer is the depth of a typed pointer, 1 for int* and 2 for int**
+	Pointer int
+}
+
+// Signature describes the function a function pointer points to, written as fn(int, int) -> int.
+type Signature struct {
+	Arguments      []UnnamedDatatype
+	ReturnDatatype UnnamedDatatype
+}
+
+func (s *Signature) String() string {
+	arguments := ""
+	for i, argument := range s.Arguments {
+		if i > 0 {
+			arguments += ", "
+		}
+		arguments += argument.String()
+	}
+	return "fn(" + arguments + ") -> " + s.ReturnDatatype.String()
+}
+
+// Equals compares two datatypes, function pointers are equal if their signatures match.
+// The length of fixed size arrays is ignored since they decay to array pointers.
+func (d UnnamedDatatype) Equals(o UnnamedDatatype) bool {
+	if d.Type != o.Type || d.IsArray != o.IsArray || d.Name != o.Name || d.Pointer != o.Pointer {
+		return false
+	}
+	if d.Signature == nil || o.Signature == nil {
+		return d.Signature == o.Signature
+	}
+	if len(d.Signature.Arguments) != len(o.Signature.Arguments) || !d.Signature.ReturnDatatype.Equals(o.Signature.ReturnDatatype) {
+		return false
+	}
+	for i := range d.Signature.Arguments {
+		if !d.Signature.Arguments[i].Equals(o.Signature.Arguments[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d UnnamedDatatype) String() string {
+	name := GetStringFromDatatype(d.Type)
+	if d.Type == STRUCT {
+		name = d.Name
+	}
+	if d.Type == FUNCTION_POINTER {
+		name = d.Signature.String()
+	}
+	name += strings.Repeat("*", d.Pointer)
+
+	if d.IsArray && d.Length > 0 {
+		return name + "[" + strconv.Itoa(d.Length) + "]"
+	}
+	if d.IsArray {
+		return name + "[]"
+	}
+	return name
+}
+
+// IsSigned returns true for signed integer types. Pointers and chr are unsigned.
+func (d UnnamedDatatype) IsSigned() bool {
+	if d.IsArray || d.Pointer > 0 {
+		return false
+	}
+	switch d.Type {
+	case CHR, PTR, STR, STRUCT, FUNCTION_POINTER, UINT_8, UINT_16, UINT_32, UINT_64:
+		return false
+	default:
+		return true
+	}
 }
 
 type NamedDatatype struct {
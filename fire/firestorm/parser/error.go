@@ -62,7 +62,7 @@ func FindErrorLineFile(code string, index int) ErrorLineFile {
 				Name:       &f,
 			})
 		} else if strings.HasPrefix(lines[i], "//@endfile") {
-			fileStack, _ = utils.Pop(fileStack, 1)
+			fileStack, _ = utils.Pop(fileStack, len(fileStack)-1)
 		}
 	}
 
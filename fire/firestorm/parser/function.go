@@ -3,6 +3,14 @@ package parser
 type FunctionCall struct {
 	Name      string
 	Arguments []*Node
+	// Callee is the expression of the called function pointer, nil when calling by name
+	Callee *Node
+}
+
+// InlineAssembly is an asm("...", operands) expression, the code refers to the result as $0 and to the operands as $1, $2, ...
+type InlineAssembly struct {
+	Code     string
+	Operands []*Node
 }
 
 type FunctionAttribute int
@@ -15,20 +23,38 @@ const (
 	External
 )
 
-func StringToFunctionAttribute(s string) FunctionAttribute {
+func (a FunctionAttribute) String() string {
+	switch a {
+	case Assembly:
+		return "assembly"
+	case NoReturn:
+		return "noreturn"
+	case Global:
+		return "global"
+	case Keep:
+		return "keep"
+	case External:
+		return "external"
+	default:
+		return "invalid"
+	}
+}
+
+// StringToFunctionAttribute returns the attribute named s, ok is false if there is none.
+func StringToFunctionAttribute(s string) (attribute FunctionAttribute, ok bool) {
 	switch s {
 	case "assembly":
-		return Assembly
+		return Assembly, true
 	case "noreturn":
-		return NoReturn
+		return NoReturn, true
 	case "global":
-		return Global
+		return Global, true
 	case "keep":
-		return Keep
+		return Keep, true
 	case "external":
-		return External
+		return External, true
 	default:
-		panic("Invalid function attribute")
+		return 0, false
 	}
 }
 
@@ -38,4 +64,17 @@ type Function struct {
 	Body           []*Node
 	ReturnDatatype UnnamedDatatype
 	Arguments      []NamedDatatype
+	// Variadic external functions accept more arguments than declared, like printf
+	Variadic bool
+	// Doc is the /// comment before the function
+	Doc string
+}
+
+// Signature returns the datatype of a pointer to f.
+func (f Function) Signature() UnnamedDatatype {
+	arguments := []UnnamedDatatype{}
+	for _, argument := range f.Arguments {
+		arguments = append(arguments, argument.UnnamedDatatype)
+	}
+	return UnnamedDatatype{Type: FUNCTION_POINTER, Signature: &Signature{Arguments: arguments, ReturnDatatype: f.ReturnDatatype}}
 }
\ No newline at end of file
@@ -0,0 +1,10 @@
+package parser
+
+// GlobalVariable is the value of a VARIABLE_DECLARATION node outside of functions.
+type GlobalVariable struct {
+	NamedDatatype
+	// Attributes of the variable, only Global and Keep are allowed
+	Attributes []FunctionAttribute
+	// Doc is the /// comment before the declaration
+	Doc string
+}
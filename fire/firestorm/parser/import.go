@@ -0,0 +1,11 @@
+package parser
+
+// Import is the value of an IMPORT node created by import "path" as alias.
+type Import struct {
+	Path  string
+	Alias string
+	// Module is the name the declarations of the imported file are prefixed with, set when it is loaded
+	Module string
+	// Importer is the module containing the import, empty for the main file
+	Importer string
+}
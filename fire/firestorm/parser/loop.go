@@ -0,0 +1,11 @@
+package parser
+
+type Loop struct {
+	// Label used by break and continue, empty if the loop has none
+	Label string
+	Body  []*Node
+	// Init is the statement before a for loop, its variable is only visible inside the loop
+	Init *Node
+	// Update is executed after every iteration of a for loop, also when using continue
+	Update *Node
+}
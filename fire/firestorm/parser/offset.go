@@ -1,6 +1,10 @@
 package parser
 
+// Offset describes the layout of an offset or struct declaration.
+// Entries are packed without any padding.
 type Offset struct {
 	Name    string
 	Entries []NamedDatatype
+	// Doc is the /// comment before the declaration
+	Doc string
 }
@@ -0,0 +1,13 @@
+package parser
+
+type Case struct {
+	// Values are constant expressions compared against the switch expression
+	Values []*Node
+	Body   []*Node
+}
+
+type Switch struct {
+	Cases []Case
+	// Default is executed if no case matches, nil if the switch has no default
+	Default []*Node
+}
@@ -9,6 +9,8 @@ const (
 	VARIABLE_DECLARATION
 
 	NUMBER
+	FLOAT
+	CHARACTER
 	STRING
 	ADD
 	SUBTRACT
@@ -22,8 +24,11 @@ const (
 
 	COMPARE
 	NOT
+	LOGICAL_AND
+	LOGICAL_OR
 
 	IF
+	SWITCH
 
 	FUNCTION_CALL
 
@@ -38,6 +43,8 @@ const (
 	CONDITIONAL_LOOP
 	POST_CONDITIONAL_LOOP
 	LOOP
+	BREAK
+	CONTINUE
 
 	SHIFT_LEFT
 	SHIFT_RIGHT
@@ -49,6 +56,19 @@ const (
 	END_EXEC
 
 	OFFSET
+
+	CAST
+
+	STRUCT_DECLARATION
+	FIELD_LOOKUP
+	FIELD_ASSIGN
+	SIZEOF
+	INLINE_ASSEMBLY
+	ARRAY_LITERAL
+	DEREFERENCE
+	ADDRESS_OF
+	DEREFERENCE_ASSIGN
+	IMPORT
 )
 
 type Node struct {
@@ -56,13 +76,23 @@ type Node struct {
 	A     *Node
 	B     *Node
 	Value any
+	Pos   int
+	// Datatype of an expression, filled in by the checker
+	Datatype UnnamedDatatype
 }
 
 func NewNode(nodeType NodeType, a *Node, b *Node, value any) *Node {
+	return NewNodeAt(nodeType, a, b, value, -1)
+}
+
+// NewNodeAt creates a node that remembers its offset in the preprocessed code.
+// Nodes created by NewNode have no position (-1).
+func NewNodeAt(nodeType NodeType, a *Node, b *Node, value any, pos int) *Node {
 	return &Node{
 		Type:  nodeType,
 		A:     a,
 		B:     b,
 		Value: value,
+		Pos:   pos,
 	}
 }
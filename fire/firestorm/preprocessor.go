@@ -1,11 +1,20 @@
 package firestorm
 
 import (
+	"errors"
+	"fire/firestorm/constexpr"
+	"fire/firestorm/diagnostic"
+	"fire/firestorm/lexer"
 	"fire/firestorm/modules"
+	"fire/firestorm/parser"
 	"fire/firestorm/utils"
 	"fmt"
+	"maps"
 	"os"
+	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -13,123 +22,404 @@ type Preprocessor struct {
 	includePaths  []string
 	includedFiles []string
 	usedPackages  []modules.Module
+	// path every included file was read from
+	sources map[string]string
+	// hash of every file that was tried to be read, "" if it didn't exist
+	read map[string]string
+	// defines visible at the current line, includes see the defines of the files including them
+	defines map[string]Define
+	// files included by the current module, other modules only get the defines of files included before
+	moduleIncludes []string
+	// macros defined or undefined (nil) by every included file, replayed when another module includes it
+	includeDefines map[string]map[string]*Define
 }
 
 func NewPreprocessor(includePaths []string) Preprocessor {
 	return Preprocessor{
-		includePaths:  includePaths,
-		includedFiles: []string{},
+		includePaths:   includePaths,
+		includedFiles:  []string{},
+		sources:        map[string]string{},
+		read:           map[string]string{},
+		defines:        map[string]Define{},
+		includeDefines: map[string]map[string]*Define{},
 	}
 }
 
+// PreprocessorError is an error in a directive or macro expansion with its location in the original file.
+type PreprocessorError struct {
+	File       string
+	Line       int
+	Column     int
+	LineString string
+	Message    string
+}
+
+func (e PreprocessorError) Error() string {
+	return e.File + ":" + strconv.Itoa(e.Line) + ":" + strconv.Itoa(e.Column) + ": " + e.Message
+}
+
+// Diagnostic converts the error so it can be reported like the errors of later stages.
+func (e PreprocessorError) Diagnostic() diagnostic.Diagnostic {
+	return diagnostic.Diagnostic{
+		Severity:   diagnostic.Error,
+		File:       e.File,
+		Line:       e.Line,
+		Column:     e.Column,
+		Message:    e.Message,
+		LineString: e.LineString,
+		Notes:      []diagnostic.Diagnostic{},
+	}
+}
+
+// located adds the location to err, errors of included files already have theirs.
+func located(err error, file string, line int, column int, lineString string) error {
+	var preprocessorError PreprocessorError
+	if errors.As(err, &preprocessorError) {
+		return err
+	}
+	return PreprocessorError{File: file, Line: line, Column: column, LineString: lineString, Message: err.Error()}
+}
+
+// condition is an open $if, $ifdef or $ifndef block.
+type condition struct {
+	directive string
+	line      int
+	// active is set if the lines of the current branch are compiled
+	active bool
+	// taken is set once a branch of the block was active, so $else stays inactive
+	taken   bool
+	hasElse bool
+}
+
 func (preprocessor *Preprocessor) tryRead(file string) *string {
 	code, err := os.ReadFile(file)
 	if err != nil {
+		preprocessor.read[file] = ""
 		return nil
 	}
 	result := string(code)
+	preprocessor.read[file] = contentHash(result)
 	return &result
 }
 
-func (preprocessor *Preprocessor) processUses(code string) string {
-	expression := regexp.MustCompile(`\$use ?<(\w*@[\w\.]*)>`)
-	matches := expression.FindAllString(code, -1)
-	for i := range matches {
-		match := matches[i]
-		use := strings.Split(strings.Split(strings.Split(match, "<")[1], ">")[0], "@")
-		name := use[0]
-		version := use[1]
+var useExpression = regexp.MustCompile(`^<(\w*)@([\w\.]*)>$`)
 
-		shouldAppend := true
+func (preprocessor *Preprocessor) use(argument string) error {
+	match := useExpression.FindStringSubmatch(argument)
+	if match == nil {
+		return errors.New("Expected <package@version> after $use")
+	}
+	name := match[1]
+	version := match[2]
 
-		for j := range preprocessor.usedPackages {
-			if preprocessor.usedPackages[j].Package == name {
-				if preprocessor.usedPackages[j].Version != version {
-					fmt.Println("[WARNING] $use " + name + " version conflict! Trying to load " + version + " but " + preprocessor.usedPackages[j].Version + " is already loaded.")
-				}
-				shouldAppend = false
-				continue
+	for j := range preprocessor.usedPackages {
+		if preprocessor.usedPackages[j].Package == name {
+			if preprocessor.usedPackages[j].Version != version {
+				fmt.Println("[WARNING] $use " + name + " version conflict! Trying to load " + version + " but " + preprocessor.usedPackages[j].Version + " is already loaded.")
 			}
+			return nil
+		}
+	}
+
+	preprocessor.usedPackages = append(preprocessor.usedPackages, modules.NewPackage(name, version))
+	return nil
+}
+
+// find reads file relative to the working directory, the include paths or from a used package. The returned path
+// identifies the file, files of packages are prefixed with the package name.
+func (preprocessor *Preprocessor) find(file string) (string, *string) {
+	path := file
+	code := preprocessor.tryRead(file)
+	for j := range preprocessor.includePaths {
+		if code != nil {
+			break
 		}
+		path = preprocessor.includePaths[j] + file
+		code = preprocessor.tryRead(path)
+	}
 
-		if shouldAppend {
-			preprocessor.usedPackages = append(preprocessor.usedPackages, modules.NewPackage(name, version))
+	for j := range preprocessor.usedPackages {
+		if packageCode, ok := preprocessor.usedPackages[j].Files[file]; ok {
+			path = preprocessor.usedPackages[j].Package + ":" + file
+			code = &packageCode
 		}
 	}
+	return path, code
+}
 
-	code = expression.ReplaceAllString(code, "")
-	return code
+// packageOf returns the name of the used package an included file was read from or "" for other files.
+func (preprocessor *Preprocessor) packageOf(file string) string {
+	for _, p := range preprocessor.usedPackages {
+		if strings.HasPrefix(preprocessor.sources[file], p.Package+":") {
+			return p.Package
+		}
+	}
+	return ""
 }
 
-func (preprocessor *Preprocessor) processIncludes(code string) string {
-	expression := regexp.MustCompile(`\$include ?<([\w/\.]*.\w*)>`)
+var includeExpression = regexp.MustCompile(`^<([\w/\.]*.\w*)>$`)
 
-	matches := expression.FindAllString(code, -1)
-	for i := range matches {
-		match := matches[i]
-		include := strings.Split(strings.Split(match, "<")[1], ">")[0]
+// include processes the included file and returns its code wrapped in //@file markers, files are only included once.
+func (preprocessor *Preprocessor) include(argument string) (string, error) {
+	match := includeExpression.FindStringSubmatch(argument)
+	if match == nil {
+		return "", errors.New("Expected <file> after $include")
+	}
+	include := match[1]
 
-		newCode := preprocessor.tryRead(include)
-		for j := range preprocessor.includePaths {
-			if newCode != nil {
-				break
+	path, newCode := preprocessor.find(include)
+	if newCode == nil {
+		return "", errors.New("Include " + include + " not found!")
+	}
+	if utils.IndexOf(preprocessor.moduleIncludes, include) != -1 {
+		return "", nil
+	}
+	preprocessor.moduleIncludes = append(preprocessor.moduleIncludes, include)
+	if utils.IndexOf(preprocessor.includedFiles, include) != -1 {
+		// the declarations are already part of the program, but the macros are needed by this module too
+		for name, define := range preprocessor.includeDefines[include] {
+			if define == nil {
+				delete(preprocessor.defines, name)
+			} else {
+				preprocessor.defines[name] = *define
 			}
-			newCode = preprocessor.tryRead(preprocessor.includePaths[j] + include)
 		}
+		return "", nil
+	}
+	preprocessor.includedFiles = append(preprocessor.includedFiles, include)
+	preprocessor.sources[include] = path
 
-		for j := range preprocessor.usedPackages {
-			if code, ok := preprocessor.usedPackages[j].Files[include]; ok {
-				newCode = &code
-			}
+	before := maps.Clone(preprocessor.defines)
+	included, err := preprocessor.processFile(include, *newCode)
+	if err != nil {
+		return "", err
+	}
+
+	changed := map[string]*Define{}
+	for name, define := range preprocessor.defines {
+		if old, ok := before[name]; !ok || !reflect.DeepEqual(old, define) {
+			changed[name] = &define
 		}
+	}
+	for name := range before {
+		if _, ok := preprocessor.defines[name]; !ok {
+			changed[name] = nil
+		}
+	}
+	preprocessor.includeDefines[include] = changed
 
-		if newCode == nil {
-			panic("Include " + include + " not found!")
+	return "\n//@file " + include + "\n" + included + "\n//@endfile", nil
+}
+
+var definedExpression = regexp.MustCompile(`\bdefined\s*(\(\s*(\w+)\s*\)|\s(\w+))`)
+
+// evaluate expands the macros of a $if condition and evaluates it with constexpr.
+// defined(NAME) and defined NAME are 1 if NAME is a macro.
+func (preprocessor *Preprocessor) evaluate(expression string) (bool, error) {
+	expression = definedExpression.ReplaceAllStringFunc(expression, func(s string) string {
+		match := definedExpression.FindStringSubmatch(s)
+		if _, ok := preprocessor.defines[match[2]+match[3]]; ok {
+			return "1"
 		}
-		if utils.IndexOf(preprocessor.includedFiles, include) == -1 {
-			preprocessor.includedFiles = append(preprocessor.includedFiles, include)
+		return "0"
+	})
 
-			code += "\n//@file " + include
-			code += "\n" + preprocessor.processIncludes(preprocessor.processUses(*newCode))
-			code += "\n//@endfile"
+	expander := macroExpander{defines: preprocessor.defines}
+	expanded, err := expander.expand(expression, nil)
+	if err != nil {
+		return false, err
+	}
+
+	reporter := diagnostic.NewReporter(expanded)
+	l := NewLexer(expanded, reporter)
+	tokens := l.Tokenize()
+	for _, token := range tokens {
+		if token.Type == lexer.ID {
+			return false, errors.New("Undefined macro " + token.Value.(string) + " in $if")
 		}
 	}
 
-	code = expression.ReplaceAllString(code, "")
-	return code
+	p := NewParser(tokens, reporter)
+	var node *parser.Node
+	p.recover(func() {
+		node = p.expression()
+	})
+	if reporter.HasErrors() {
+		return false, errors.New(reporter.Diagnostics[0].Message + " in $if")
+	}
+	if node == nil || p.current.Type != lexer.END_OF_FILE {
+		return false, errors.New("Invalid $if condition " + strings.TrimSpace(expression))
+	}
+
+	value, err := constexpr.Evaluate(node)
+	return value != 0, err
 }
 
-type Define struct {
-	name  string
-	value string
+// directive executes the $ directive on line of a file. Only conditional directives are executed in inactive branches.
+func (preprocessor *Preprocessor) directive(text string, line int, active bool, conditions *[]condition) (string, error) {
+	n := identifier(text[1:])
+	name := text[1 : 1+n]
+	argument := strings.TrimSpace(text[1+n:])
+
+	switch name {
+	case "if", "ifdef", "ifndef":
+		c := condition{directive: name, line: line}
+		if active {
+			var err error
+			switch name {
+			case "if":
+				c.active, err = preprocessor.evaluate(argument)
+			case "ifdef", "ifndef":
+				macro := strings.TrimSpace(stripComment(argument))
+				if identifier(macro) != len(macro) || macro == "" {
+					return "", errors.New("Expected macro name after $" + name)
+				}
+				_, defined := preprocessor.defines[macro]
+				c.active = defined == (name == "ifdef")
+			}
+			if err != nil {
+				return "", err
+			}
+			c.taken = c.active
+		} else {
+			// no branch of a block inside an inactive branch is compiled
+			c.taken = true
+		}
+		*conditions = append(*conditions, c)
+		return "", nil
+	case "else", "endif":
+		if len(*conditions) == 0 {
+			return "", errors.New("$" + name + " without $if")
+		}
+		c := &(*conditions)[len(*conditions)-1]
+		if name == "endif" {
+			*conditions = (*conditions)[:len(*conditions)-1]
+			return "", nil
+		}
+		if c.hasElse {
+			return "", errors.New("Duplicate $else for $" + c.directive + " in line " + strconv.Itoa(c.line))
+		}
+		c.hasElse = true
+		c.active = !c.taken
+		c.taken = true
+		return "", nil
+	}
+
+	if !active {
+		return "", nil
+	}
+
+	switch name {
+	case "define":
+		define, err := parseDefine(argument)
+		if err != nil {
+			return "", err
+		}
+		preprocessor.defines[define.Name] = define
+	case "undef":
+		macro := strings.TrimSpace(stripComment(argument))
+		if identifier(macro) != len(macro) || macro == "" {
+			return "", errors.New("Expected macro name after $undef")
+		}
+		delete(preprocessor.defines, macro)
+	case "include":
+		return preprocessor.include(strings.TrimSpace(stripComment(argument)))
+	case "use":
+		return "", preprocessor.use(strings.TrimSpace(stripComment(argument)))
+	default:
+		return "", errors.New("Unknown directive $" + name)
+	}
+	return "", nil
 }
 
-func (preprocessor Preprocessor) processDefines(code string) string {
-	expression := regexp.MustCompile(`\$define ([^ ]*) (.*)`)
+// processFile runs the directives of a file and expands its macros. Directives and inactive lines are replaced
+// by empty lines and included files are appended, so diagnostics can report the original line numbers.
+func (preprocessor *Preprocessor) processFile(file string, code string) (string, error) {
+	lines := strings.Split(code, "\n")
+	result := []string{}
+	included := ""
+	conditions := []condition{}
+	expander := macroExpander{defines: preprocessor.defines}
 
-	defines := []Define{}
+	for i := 0; i < len(lines); i++ {
+		active := len(conditions) == 0 || conditions[len(conditions)-1].active
+		trimmed := strings.TrimSpace(lines[i])
 
-	matches := expression.FindAllString(code, -1)
-	for i := range matches {
-		match := matches[i]
+		if strings.HasPrefix(trimmed, "$") && expander.commentDepth == 0 {
+			line := i + 1
+			// a \ at the end of a directive continues it on the next line
+			text := trimmed
+			for strings.HasSuffix(text, "\\") && i+1 < len(lines) {
+				result = append(result, "")
+				i++
+				text = strings.TrimSuffix(text, "\\") + " " + strings.TrimSpace(lines[i])
+			}
+			result = append(result, "")
 
-		defineSplit := strings.Split(match, " ")
+			code, err := preprocessor.directive(text, line, active, &conditions)
+			if err != nil {
+				return "", located(err, file, line, strings.Index(lines[line-1], "$")+1, lines[line-1])
+			}
+			included += code
+			continue
+		}
 
-		defines = append(defines, Define{
-			name:  defineSplit[1],
-			value: strings.Join(defineSplit[2:], " "),
-		})
-	}
+		if !active {
+			result = append(result, "")
+			continue
+		}
 
-	code = expression.ReplaceAllString(code, "")
+		// the arguments of a macro call can continue on the following lines, they are joined into the first one
+		first := i
+		depth := expander.commentDepth
+		expanded, err := expander.expand(lines[i], nil)
+		text := stripComment(lines[i])
+		// offsets of the joined lines in text
+		starts := []int{0}
+		for errors.As(err, &unterminatedError{}) && i+1 < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i+1]), "$") {
+			i++
+			text += " "
+			starts = append(starts, len(text))
+			text += stripComment(lines[i])
+			expander.commentDepth = depth
+			expanded, err = expander.expand(text, nil)
+		}
+		if err != nil {
+			line, column := first, 0
+			var macro macroError
+			if errors.As(err, &macro) {
+				joined := sort.Search(len(starts), func(j int) bool { return starts[j] > macro.pos }) - 1
+				line, column = first+joined, macro.pos-starts[joined]
+			}
+			return "", located(err, file, line+1, column+1, lines[line])
+		}
+		result = append(result, expanded)
+		for ; first < i; first++ {
+			result = append(result, "")
+		}
+	}
 
-	for i := range defines {
-		code = strings.ReplaceAll(code, defines[i].name, defines[i].value)
+	if len(conditions) > 0 {
+		c := conditions[len(conditions)-1]
+		return "", located(errors.New("Missing $endif for $"+c.directive), file, c.line, strings.Index(lines[c.line-1], "$")+1, lines[c.line-1])
 	}
 
-	return code
+	return strings.Join(result, "\n") + included, nil
+}
+
+// Defines returns the macros defined by code, ignoring conditional compilation.
+func Defines(code string) []Define {
+	defines := []Define{}
+	for _, line := range strings.Split(code, "\n") {
+		if argument, ok := strings.CutPrefix(strings.TrimSpace(line), "$define"); ok {
+			if define, err := parseDefine(argument); err == nil {
+				defines = append(defines, define)
+			}
+		}
+	}
+	return defines
 }
 
-func (preprocessor *Preprocessor) Process(code string) string {
-	return preprocessor.processDefines(preprocessor.processIncludes(preprocessor.processUses(code)))
+func (preprocessor *Preprocessor) Process(code string) (string, error) {
+	return preprocessor.processFile("<input>", code)
 }
@@ -0,0 +1,160 @@
+package llvm
+
+import (
+	"fire/firestorm/parser"
+	"fire/firestorm/utils"
+	"strconv"
+	"strings"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// callingConvention describes the registers a target passes integer arguments and the return value in.
+// Assembly functions see their arguments in these registers, like the body of a C function would.
+type callingConvention struct {
+	arguments []string
+	result    string
+	// callerSaved registers may be modified by the assembly code
+	callerSaved []string
+	// clobbers added to every inline assembly call
+	clobbers []string
+}
+
+var x86Clobbers = []string{"~{memory}", "~{dirflag}", "~{fpsr}", "~{flags}"}
+
+var conventions = []struct {
+	prefix     string
+	convention callingConvention
+}{
+	{"x86_64-pc-win32", callingConvention{
+		arguments:   []string{"rcx", "rdx", "r8", "r9"},
+		result:      "rax",
+		callerSaved: []string{"rax", "rcx", "rdx", "r8", "r9", "r10", "r11"},
+		clobbers:    x86Clobbers,
+	}},
+	{"x86_64", callingConvention{
+		arguments:   []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"},
+		result:      "rax",
+		callerSaved: []string{"rax", "rcx", "rdx", "rsi", "rdi", "r8", "r9", "r10", "r11"},
+		clobbers:    x86Clobbers,
+	}},
+	{"aarch64", callingConvention{
+		arguments:   []string{"x0", "x1", "x2", "x3", "x4", "x5", "x6", "x7"},
+		result:      "x0",
+		callerSaved: []string{"x0", "x1", "x2", "x3", "x4", "x5", "x6", "x7", "x8", "x9", "x10", "x11", "x12", "x13", "x14", "x15", "x16", "x17"},
+		clobbers:    []string{"~{memory}"},
+	}},
+	{"riscv64", callingConvention{
+		arguments:   []string{"a0", "a1", "a2", "a3", "a4", "a5", "a6", "a7"},
+		result:      "a0",
+		callerSaved: []string{"a0", "a1", "a2", "a3", "a4", "a5", "a6", "a7", "t0", "t1", "t2", "t3", "t4", "t5", "t6"},
+		clobbers:    []string{"~{memory}"},
+	}},
+}
+
+func (b *LLVM) callingConvention() *callingConvention {
+	for _, c := range conventions {
+		if strings.HasPrefix(b.target, c.prefix) {
+			return &c.convention
+		}
+	}
+	return nil
+}
+
+// genericConstraints returns "=r" for the result and "r" for every argument, the assembly code refers
+// to them as $0 (result) and $1, $2, ... (arguments).
+func genericConstraints(result types.Type, arguments int, clobbers []string) string {
+	constraints := []string{}
+	if !result.Equal(types.Void) {
+		constraints = append(constraints, "=r")
+	}
+	for i := 0; i < arguments; i++ {
+		constraints = append(constraints, "r")
+	}
+	return strings.Join(append(constraints, clobbers...), ",")
+}
+
+// constraints binds the result and the arguments to the registers of the calling convention
+// and marks the remaining caller saved registers as clobbered.
+func (c *callingConvention) constraints(result types.Type, arguments int) (string, bool) {
+	if arguments > len(c.arguments) {
+		return "", false
+	}
+
+	constraints := []string{}
+	used := []string{}
+	if !result.Equal(types.Void) {
+		constraints = append(constraints, "={"+c.result+"}")
+		used = append(used, c.result)
+	}
+	for _, register := range c.arguments[:arguments] {
+		constraints = append(constraints, "{"+register+"}")
+		used = append(used, register)
+	}
+	for _, register := range c.callerSaved {
+		if utils.IndexOf(used, register) == -1 {
+			constraints = append(constraints, "~{"+register+"}")
+		}
+	}
+	return strings.Join(append(constraints, c.clobbers...), ","), true
+}
+
+func newInlineAsm(code string, constraints string, result types.Type, arguments []types.Type) *ir.InlineAsm {
+	asm := ir.NewInlineAsm(types.NewPointer(types.NewFunc(result, arguments...)), code, constraints)
+	asm.SideEffect = true
+	return asm
+}
+
+// generateAssemblyFunction generates a function whose body is a single inline assembly call.
+func (b *LLVM) generateAssemblyFunction(f *ir.Func, af parser.Function, cf *CompiledFunction) {
+	code := af.Body[0].Value.(string)
+
+	arguments := []types.Type{}
+	values := []value.Value{}
+	for _, param := range f.Params {
+		arguments = append(arguments, param.Typ)
+		values = append(values, param)
+	}
+
+	var constraints string
+	if convention := b.callingConvention(); convention != nil {
+		var ok bool
+		constraints, ok = convention.constraints(f.Sig.RetType, len(arguments))
+		if !ok {
+			b.error("Assembly function "+af.Name+" takes more than "+strconv.Itoa(len(convention.arguments))+" arguments", cf)
+		}
+	} else {
+		constraints = genericConstraints(f.Sig.RetType, len(arguments), []string{"~{memory}"})
+	}
+
+	entry := f.NewBlock("entry")
+	call := entry.NewCall(newInlineAsm(code, constraints, f.Sig.RetType, arguments), values...)
+	if f.Sig.RetType.Equal(types.Void) {
+		entry.NewRet(nil)
+	} else {
+		entry.NewRet(call)
+	}
+}
+
+// generateInlineAssembly generates an asm("...", operands) expression, which returns the value written to $0.
+func (b *LLVM) generateInlineAssembly(exp *parser.Node, block *ir.Block, cf *CompiledFunction) (value.Value, *ir.Block) {
+	assembly := exp.Value.(parser.InlineAssembly)
+
+	arguments := []types.Type{}
+	values := []value.Value{}
+	for _, operand := range assembly.Operands {
+		var x value.Value
+		x, block = b.generateExpression(operand, block, cf)
+		arguments = append(arguments, x.Type())
+		values = append(values, x)
+	}
+
+	clobbers := []string{"~{memory}"}
+	if convention := b.callingConvention(); convention != nil {
+		clobbers = convention.clobbers
+	}
+	constraints := genericConstraints(types.I64, len(arguments), clobbers)
+	return block.NewCall(newInlineAsm(assembly.Code, constraints, types.I64, arguments), values...), block
+}
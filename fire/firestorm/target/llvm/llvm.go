@@ -2,9 +2,9 @@ package llvm
 
 import (
 	"fire/firestorm/constexpr"
+	"fire/firestorm/diagnostic"
 	"fire/firestorm/parser"
 	"fire/firestorm/utils"
-	"fmt"
 	"strconv"
 
 	"github.com/llir/llvm/ir"
@@ -17,54 +17,135 @@ import (
 type GlobalVariable struct {
 	varivable *ir.Global
 	final     bool
+	pos       int
 }
 
 type LLVM struct {
 	global          *parser.Node
 	globalVariables map[string]GlobalVariable
 	functions       map[string]*ir.Func
+	structs         map[string]*types.StructType
+	structFields    map[string][]parser.NamedDatatype
 	module          *ir.Module
 	globalId        int
 	ptrType         types.Type
 	target          string
+	reporter        *diagnostic.Reporter
+	pos             int
+	// definitions with the keep attribute
+	used []constant.Constant
+	// index of the unit being compiled and of the unit defining every function and global variable
+	unit  int
+	owner map[string]int
+	// definitions of other units referenced by the current unit
+	referenced map[string]bool
+	// errors already reported while compiling an earlier unit
+	reported map[string]bool
 }
 
-func NewLLVM(global *parser.Node, target string) *LLVM {
+// Unit is a part of the program compiled into its own module. Functions and global variables of other units
+// are referenced through declarations.
+type Unit struct {
+	Name  string
+	Nodes []*parser.Node
+}
+
+// compileError is used to abort the current function after an error was reported.
+type compileError struct{}
+
+func NewLLVM(global *parser.Node, target string, reporter *diagnostic.Reporter) *LLVM {
 	return &LLVM{
 		global:          global,
 		globalVariables: make(map[string]GlobalVariable),
 		functions:       make(map[string]*ir.Func),
+		structs:         make(map[string]*types.StructType),
+		structFields:    make(map[string][]parser.NamedDatatype),
 		globalId:        0,
 		ptrType:         types.I64,
 		target:          target,
+		reporter:        reporter,
+		pos:             -1,
+		owner:           make(map[string]int),
+		referenced:      make(map[string]bool),
+		reported:        make(map[string]bool),
 	}
 }
 
-func (l *LLVM) error(message string, cf *CompiledFunction) {
-	if cf == nil {
-		fmt.Println("error:", message)
-	} else {
-		fmt.Println("error: (in:", cf.name+"):", message)
+func (l *LLVM) error(message string, cf *CompiledFunction, notes ...diagnostic.Diagnostic) {
+	if cf != nil {
+		message += " (in " + cf.name + ")"
+	}
+	// declarations are generated for every unit, so their errors would be reported once per unit
+	key := strconv.Itoa(l.pos) + ":" + message
+	if !l.reported[key] {
+		l.reported[key] = true
+		l.reporter.Error(l.pos, message, notes...)
 	}
-	panic("Parser failed")
+	panic(compileError{})
+}
+
+// reference remembers that the current unit uses the function or global variable name.
+func (l *LLVM) reference(name string) {
+	if unit, ok := l.owner[name]; ok && unit != l.unit {
+		l.referenced[name] = true
+	}
+}
+
+// at remembers the position of the node currently being compiled for error reporting.
+func (l *LLVM) at(node *parser.Node) {
+	if node.Pos >= 0 {
+		l.pos = node.Pos
+	}
+}
+
+// recover catches a compileError raised by fn so compilation can continue with the next declaration.
+func (l *LLVM) recover(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(compileError); !ok {
+				panic(r)
+			}
+		}
+	}()
+
+	fn()
 }
 
 func (l *LLVM) findFunction(name string, cf *CompiledFunction) *ir.Func {
 	if f, ok := l.functions[name]; ok {
+		l.reference(name)
 		return f
 	}
 	l.error("Function "+name+" not found!", cf)
 	panic("?")
 }
 
+// isVariable returns true if name refers to a local or global variable instead of a function.
+func (l *LLVM) isVariable(name string, cf *CompiledFunction) bool {
+	if cf != nil {
+		if _, ok := cf.findLocal(name); ok {
+			return true
+		}
+	}
+	_, ok := l.globalVariables[name]
+	return ok
+}
+
 func (l *LLVM) findVariable(name string, cf *CompiledFunction, assign bool) (value.Value, types.Type) {
+	if cf != nil {
+		if v, ok := cf.findLocal(name); ok {
+			return v, v.ElemType
+		}
+	}
 	if v, ok := l.globalVariables[name]; ok {
 		if assign && v.final {
-			panic("Cannot assign to final varibale " + name)
+			l.error("Cannot assign to final variable "+name, cf, l.reporter.Note(v.pos, name+" declared here"))
 		}
+		l.reference(name)
 		return v.varivable, v.varivable.ContentType
 	}
-	return cf.findVariable(name, l.error)
+	l.error("Variable "+name+" not found!", cf)
+	panic("?")
 }
 
 func (b *LLVM) newGlobalString(v string) value.Value {
@@ -73,12 +154,59 @@ func (b *LLVM) newGlobalString(v string) value.Value {
 
 	str := constant.NewCharArrayFromString(v + "\x00")
 	globalStr := b.module.NewGlobalDef(id, str)
+	globalStr.Linkage = enum.LinkagePrivate
+	globalStr.UnnamedAddr = enum.UnnamedAddrUnnamedAddr
+	globalStr.Immutable = true
 
 	zero := constant.NewInt(types.I64, 0)
 	return constant.NewGetElementPtr(str.Typ, globalStr, zero, zero)
 }
 
-func (b *LLVM) compareToLLVM(c parser.Compare) enum.IPred {
+func (b *LLVM) compareToLLVMFloat(c parser.Compare) enum.FPred {
+	switch c {
+	case parser.More:
+		return enum.FPredOGT
+	case parser.Less:
+		return enum.FPredOLT
+	case parser.MoreEquals:
+		return enum.FPredOGE
+	case parser.LessEquals:
+		return enum.FPredOLE
+	case parser.Equals:
+		return enum.FPredOEQ
+	case parser.NotEquals:
+		return enum.FPredUNE
+	}
+	panic("?")
+}
+
+// isUnsigned64 returns true for datatypes which stay unsigned after being widened to 64 bit.
+func isUnsigned64(d parser.UnnamedDatatype) bool {
+	if d.IsSigned() {
+		return false
+	}
+	return d.IsArray || d.Pointer > 0 || d.Type == parser.PTR || d.Type == parser.STR || d.Type == parser.STRUCT || d.Type == parser.UINT_64
+}
+
+func isFloat(v value.Value) bool {
+	_, ok := v.Type().(*types.FloatType)
+	return ok
+}
+
+func (b *LLVM) compareToLLVM(c parser.Compare, signed bool) enum.IPred {
+	if !signed {
+		switch c {
+		case parser.More:
+			return enum.IPredUGT
+		case parser.Less:
+			return enum.IPredULT
+		case parser.MoreEquals:
+			return enum.IPredUGE
+		case parser.LessEquals:
+			return enum.IPredULE
+		}
+	}
+
 	switch c {
 	case parser.More:
 		return enum.IPredSGT
@@ -105,6 +233,15 @@ func (b *LLVM) datatypeArraySelect(d parser.UnnamedDatatype, single types.Type,
 }
 
 func (b *LLVM) datatypeToLLVM(d parser.UnnamedDatatype) types.Type {
+	if d.Pointer > 0 {
+		// an array of typed pointers is one more level of indirection
+		pointer := b.datatypeToLLVM(parser.UnnamedDatatype{Type: d.Type, Name: d.Name, Signature: d.Signature})
+		for i := 0; i < d.Pointer; i++ {
+			pointer = types.NewPointer(pointer)
+		}
+		return b.datatypeArraySelect(d, pointer, types.NewPointer(pointer))
+	}
+
 	switch d.Type {
 	case parser.INT:
 		return b.datatypeArraySelect(d, types.I64, types.I64Ptr)
@@ -118,15 +255,36 @@ func (b *LLVM) datatypeToLLVM(d parser.UnnamedDatatype) types.Type {
 		return b.datatypeArraySelect(d, b.ptrType, types.NewPointer(b.ptrType))
 	case parser.INT_32:
 		return b.datatypeArraySelect(d, types.I32, types.I32Ptr)
-	case parser.INT_16:
+	case parser.INT_16, parser.UINT_16:
 		return b.datatypeArraySelect(d, types.I16, types.I16Ptr)
+	case parser.INT_8, parser.UINT_8:
+		return b.datatypeArraySelect(d, types.I8, types.I8Ptr)
+	case parser.INT_64, parser.UINT_64:
+		return b.datatypeArraySelect(d, types.I64, types.I64Ptr)
+	case parser.UINT_32:
+		return b.datatypeArraySelect(d, types.I32, types.I32Ptr)
+	case parser.FLOAT_32:
+		return b.datatypeArraySelect(d, types.Float, types.NewPointer(types.Float))
+	case parser.FLOAT_64:
+		return b.datatypeArraySelect(d, types.Double, types.NewPointer(types.Double))
+	case parser.STRUCT:
+		// structs are always referenced through a pointer
+		reference := types.NewPointer(b.structs[d.Name])
+		return b.datatypeArraySelect(d, reference, types.NewPointer(reference))
+	case parser.FUNCTION_POINTER:
+		parameters := []types.Type{}
+		for _, argument := range d.Signature.Arguments {
+			parameters = append(parameters, b.datatypeToLLVM(argument))
+		}
+		pointer := types.NewPointer(types.NewFunc(b.datatypeToLLVM(d.Signature.ReturnDatatype), parameters...))
+		return b.datatypeArraySelect(d, pointer, types.NewPointer(pointer))
 	default:
 		panic("Invalid datatype")
 	}
 }
 
 func (b *LLVM) datatypeToSize(d parser.UnnamedDatatype) int {
-	if d.IsArray {
+	if d.IsArray || d.Pointer > 0 {
 		return int(b.ptrType.(*types.IntType).BitSize) / 8
 	}
 
@@ -143,99 +301,373 @@ func (b *LLVM) datatypeToSize(d parser.UnnamedDatatype) int {
 		return int(b.ptrType.(*types.IntType).BitSize) / 8
 	case parser.INT_32:
 		return 4
-	case parser.INT_16:
+	case parser.INT_16, parser.UINT_16:
 		return 2
+	case parser.INT_8, parser.UINT_8:
+		return 1
+	case parser.INT_64, parser.UINT_64:
+		return 8
+	case parser.UINT_32:
+		return 4
+	case parser.STRUCT, parser.FUNCTION_POINTER:
+		return int(b.ptrType.(*types.IntType).BitSize) / 8
+	case parser.FLOAT_32:
+		return 4
+	case parser.FLOAT_64:
+		return 8
 	default:
 		panic("Invalid datatype")
 	}
 }
 
-func (b *LLVM) generateExpressionRaw(exp *parser.Node, block *ir.Block, cf *CompiledFunction) value.Value {
+// structSize returns the size of the struct contents, in contrast to datatypeToSize which returns the size of a reference.
+func (b *LLVM) structSize(name string) int {
+	size := 0
+	for _, entry := range b.structFields[name] {
+		size += b.datatypeToSize(entry.UnnamedDatatype)
+	}
+	return size
+}
+
+// generateFieldPointer returns the address of the field looked up by exp.
+func (b *LLVM) generateFieldPointer(exp *parser.Node, block *ir.Block, cf *CompiledFunction) (value.Value, *ir.Block) {
+	object, block := b.generateExpressionRaw(exp.A, block, cf)
+	b.at(exp)
+
+	var structType *types.StructType
+	if ptr, ok := object.Type().(*types.PointerType); ok {
+		structType, _ = ptr.ElemType.(*types.StructType)
+	}
+	if structType == nil {
+		b.error("Cannot access field "+exp.Value.(string)+" of non struct value", cf)
+	}
+
+	for i, entry := range b.structFields[structType.Name()] {
+		if entry.Name == exp.Value.(string) {
+			zero := constant.NewInt(types.I32, 0)
+			return block.NewGetElementPtr(structType, object, zero, constant.NewInt(types.I32, int64(i))), block
+		}
+	}
+	b.error("No field "+exp.Value.(string)+" in struct "+structType.Name(), cf)
+	panic("?")
+}
+
+// generateExpressionRaw returns the value of exp and the block following code has to be generated in.
+func (b *LLVM) generateExpressionRaw(exp *parser.Node, block *ir.Block, cf *CompiledFunction) (value.Value, *ir.Block) {
+	b.at(exp)
 
 	switch exp.Type {
-	case parser.NUMBER:
-		return constant.NewInt(types.I64, int64(exp.Value.(int)))
+	case parser.NUMBER, parser.CHARACTER:
+		return constant.NewInt(types.I64, int64(exp.Value.(int))), block
+	case parser.FLOAT:
+		return constant.NewFloat(types.Double, exp.Value.(float64)), block
 	case parser.STRING:
-		return b.newGlobalString(exp.Value.(string))
+		return b.newGlobalString(exp.Value.(string)), block
 	case parser.COMPARE:
-		cmp := block.NewICmp(b.compareToLLVM(exp.Value.(parser.Compare)), b.generateExpression(exp.A, block, cf), b.generateExpression(exp.B, block, cf))
-		return block.NewZExt(cmp, types.I64)
+		x, block := b.generateExpression(exp.A, block, cf)
+		y, block := b.generateExpression(exp.B, block, cf)
+		if isFloat(x) || isFloat(y) {
+			x = b.convert(x, exp.A.Datatype.IsSigned(), types.Double, block)
+			y = b.convert(y, exp.B.Datatype.IsSigned(), types.Double, block)
+			cmp := block.NewFCmp(b.compareToLLVMFloat(exp.Value.(parser.Compare)), x, y)
+			return block.NewZExt(cmp, types.I64), block
+		}
+		signed := !isUnsigned64(exp.A.Datatype) && !isUnsigned64(exp.B.Datatype)
+		cmp := block.NewICmp(b.compareToLLVM(exp.Value.(parser.Compare), signed), x, y)
+		return block.NewZExt(cmp, types.I64), block
 	case parser.NOT:
-		cmp := block.NewICmp(enum.IPredEQ, b.generateExpression(exp.A, block, cf), constant.NewInt(types.I64, 0))
-		return block.NewZExt(cmp, types.I64)
-	case parser.ADD:
-		return block.NewAdd(b.generateExpression(exp.A, block, cf), b.generateExpression(exp.B, block, cf))
-	case parser.SUBTRACT:
-		return block.NewSub(b.generateExpression(exp.A, block, cf), b.generateExpression(exp.B, block, cf))
-	case parser.MULTIPLY:
-		return block.NewMul(b.generateExpression(exp.A, block, cf), b.generateExpression(exp.B, block, cf))
-	case parser.DIVIDE:
-		return block.NewSDiv(b.generateExpression(exp.A, block, cf), b.generateExpression(exp.B, block, cf))
-	case parser.MODULO:
-		return block.NewSRem(b.generateExpression(exp.A, block, cf), b.generateExpression(exp.B, block, cf))
-	case parser.OR:
-		return block.NewOr(b.generateExpression(exp.A, block, cf), b.generateExpression(exp.B, block, cf))
-	case parser.AND:
-		return block.NewAnd(b.generateExpression(exp.A, block, cf), b.generateExpression(exp.B, block, cf))
-	case parser.XOR:
-		return block.NewXor(b.generateExpression(exp.A, block, cf), b.generateExpression(exp.B, block, cf))
+		cmp, block := b.generateCondition(exp.A, block, cf)
+		return block.NewZExt(block.NewXor(cmp, constant.True), types.I64), block
+	case parser.LOGICAL_AND, parser.LOGICAL_OR:
+		return b.generateLogical(exp, block, cf)
+	case parser.ADD, parser.SUBTRACT, parser.MULTIPLY, parser.DIVIDE, parser.MODULO:
+		return b.generateArithmetic(exp, block, cf)
+	case parser.OR, parser.AND, parser.XOR, parser.SHIFT_LEFT, parser.SHIFT_RIGHT:
+		x, block := b.generateExpression(exp.A, block, cf)
+		y, block := b.generateExpression(exp.B, block, cf)
+		switch exp.Type {
+		case parser.OR:
+			return block.NewOr(x, y), block
+		case parser.AND:
+			return block.NewAnd(x, y), block
+		case parser.XOR:
+			return block.NewXor(x, y), block
+		case parser.SHIFT_LEFT:
+			return block.NewShl(x, y), block
+		}
+		if exp.A.Datatype.IsSigned() {
+			return block.NewAShr(x, y), block
+		}
+		return block.NewLShr(x, y), block
 	case parser.BIT_NOT:
-		return block.NewXor(b.generateExpression(exp.A, block, cf), constant.NewInt(types.I64, -1))
-	case parser.SHIFT_LEFT:
-		return block.NewShl(b.generateExpression(exp.A, block, cf), b.generateExpression(exp.B, block, cf))
-	case parser.SHIFT_RIGHT:
-		return block.NewLShr(b.generateExpression(exp.A, block, cf), b.generateExpression(exp.B, block, cf))
+		x, block := b.generateExpression(exp.A, block, cf)
+		return block.NewXor(x, constant.NewInt(types.I64, -1)), block
 	case parser.FUNCTION_CALL:
 		fc := exp.Value.(parser.FunctionCall)
 		return b.generateFunctionCall(fc, block, cf)
 	case parser.VARIABLE_LOOKUP:
+		if f, ok := b.functions[exp.Value.(string)]; ok && !b.isVariable(exp.Value.(string), cf) {
+			// address of a function
+			b.reference(exp.Value.(string))
+			return f, block
+		}
 		v, t := b.findVariable(exp.Value.(string), cf, false)
 		// if _, ok := v.ElemType.(*types.PointerType); ok {
 		// 	l := block.NewLoad(v.ElemType, v)
 		// 	return b.autoTypeCast(l, b.ptrType, block)
 		// }
-		return block.NewLoad(t, v)
+		return block.NewLoad(t, v), block
 
 	case parser.VARIABLE_LOOKUP_ARRAY:
 		v, t := b.findVariable(exp.Value.(string), cf, false)
+		i, block := b.generateExpression(exp.A, block, cf)
 		ptr := block.NewLoad(t, v)
-		i := b.generateExpression(exp.A, block, cf)
 
 		if arrPtr, ok := ptr.ElemType.(*types.PointerType); ok {
 			indexed := block.NewGetElementPtr(arrPtr.ElemType, ptr, i)
-			return block.NewLoad(arrPtr.ElemType, indexed)
+			return block.NewLoad(arrPtr.ElemType, indexed), block
 		} else {
 			// bit index
 			index := block.NewShl(constant.NewInt(types.I64, 1), i)
 			x := block.NewAnd(ptr, b.autoTypeCast(index, ptr.Type(), block))
-			return x
+			return x, block
 		}
 
+	case parser.PLUS:
+		return b.generateExpression(exp.A, block, cf)
 	case parser.MINUS:
-		return block.NewMul(b.generateExpression(exp.A, block, cf), constant.NewInt(types.I64, -1))
+		x, block := b.generateExpression(exp.A, block, cf)
+		if isFloat(x) {
+			return block.NewFNeg(x), block
+		}
+		return block.NewMul(x, constant.NewInt(types.I64, -1)), block
+	case parser.CAST:
+		x, block := b.generateExpression(exp.A, block, cf)
+		to := exp.Value.(parser.UnnamedDatatype)
+		if isFloat(x) && !to.IsSigned() {
+			if _, ok := b.datatypeToLLVM(to).(*types.IntType); ok {
+				x = block.NewFPToUI(x, types.I64)
+			}
+		}
+		return b.convert(x, exp.A.Datatype.IsSigned(), b.datatypeToLLVM(to), block), block
+	case parser.FIELD_LOOKUP:
+		ptr, block := b.generateFieldPointer(exp, block, cf)
+		return block.NewLoad(ptr.Type().(*types.PointerType).ElemType, ptr), block
+	case parser.DEREFERENCE:
+		ptr, block := b.generateTypedPointer(exp.A, block, cf)
+		return block.NewLoad(ptr.Type().(*types.PointerType).ElemType, ptr), block
+	case parser.ADDRESS_OF:
+		v, _ := b.findVariable(exp.Value.(string), cf, false)
+		return v, block
+	case parser.INLINE_ASSEMBLY:
+		return b.generateInlineAssembly(exp, block, cf)
+	case parser.SIZEOF:
+		d := exp.Value.(parser.UnnamedDatatype)
+		if d.Type == parser.STRUCT && !d.IsArray {
+			return constant.NewInt(types.I64, int64(b.structSize(d.Name))), block
+		}
+		return constant.NewInt(types.I64, int64(b.datatypeToSize(d))), block
 	default:
 		panic("Unknown " + strconv.Itoa(int(exp.Type)))
 	}
 
 }
 
-func (b *LLVM) generateExpression(exp *parser.Node, block *ir.Block, cf *CompiledFunction) value.Value {
-	return b.autoTypeCast(b.generateExpressionRaw(exp, block, cf), types.I64, block)
+// generateExpression computes integers and pointers as i64 and floats as double.
+func (b *LLVM) generateExpression(exp *parser.Node, block *ir.Block, cf *CompiledFunction) (value.Value, *ir.Block) {
+	x, block := b.generateExpressionRaw(exp, block, cf)
+	if isFloat(x) {
+		return b.autoTypeCast(x, types.Double, block), block
+	}
+	return b.convert(x, exp.Datatype.IsSigned(), types.I64, block), block
+}
+
+// generateTypedPointer computes the address exp points to with the LLVM pointer type of its datatype.
+func (b *LLVM) generateTypedPointer(exp *parser.Node, block *ir.Block, cf *CompiledFunction) (value.Value, *ir.Block) {
+	x, block := b.generateExpression(exp, block, cf)
+	return block.NewIntToPtr(x, b.datatypeToLLVM(exp.Datatype)), block
 }
 
-func (b *LLVM) generateFunctionCall(fc parser.FunctionCall, block *ir.Block, cf *CompiledFunction) *ir.InstCall {
+// elementSize returns the size of the values a typed pointer points to, other datatypes use byte offsets.
+func (b *LLVM) elementSize(d parser.UnnamedDatatype) int64 {
+	if d.IsArray || d.Pointer == 0 {
+		return 1
+	}
+	d.Pointer--
+	return int64(b.datatypeToSize(d))
+}
+
+func (b *LLVM) generateArithmetic(exp *parser.Node, block *ir.Block, cf *CompiledFunction) (value.Value, *ir.Block) {
+	x, block := b.generateExpression(exp.A, block, cf)
+	y, block := b.generateExpression(exp.B, block, cf)
+
+	// typed pointer arithmetic counts in elements
+	if exp.Type == parser.ADD || exp.Type == parser.SUBTRACT {
+		sizeA := b.elementSize(exp.A.Datatype)
+		sizeB := b.elementSize(exp.B.Datatype)
+		if exp.Type == parser.SUBTRACT && exp.Datatype.Pointer == 0 && (sizeA > 1 || sizeB > 1) {
+			// the distance between two pointers, a typed pointer and an array count in elements of the pointer
+			return block.NewSDiv(block.NewSub(x, y), constant.NewInt(types.I64, max(sizeA, sizeB))), block
+		}
+		if sizeA > 1 {
+			y = block.NewMul(y, constant.NewInt(types.I64, sizeA))
+		}
+		if sizeB > 1 {
+			x = block.NewMul(x, constant.NewInt(types.I64, sizeB))
+		}
+	}
+
+	if isFloat(x) || isFloat(y) {
+		x = b.convert(x, exp.A.Datatype.IsSigned(), types.Double, block)
+		y = b.convert(y, exp.B.Datatype.IsSigned(), types.Double, block)
+
+		switch exp.Type {
+		case parser.ADD:
+			return block.NewFAdd(x, y), block
+		case parser.SUBTRACT:
+			return block.NewFSub(x, y), block
+		case parser.MULTIPLY:
+			return block.NewFMul(x, y), block
+		case parser.DIVIDE:
+			return block.NewFDiv(x, y), block
+		case parser.MODULO:
+			return block.NewFRem(x, y), block
+		}
+	}
+
+	switch exp.Type {
+	case parser.ADD:
+		return block.NewAdd(x, y), block
+	case parser.SUBTRACT:
+		return block.NewSub(x, y), block
+	case parser.MULTIPLY:
+		return block.NewMul(x, y), block
+	case parser.DIVIDE:
+		if exp.Datatype.IsSigned() {
+			return block.NewSDiv(x, y), block
+		}
+		return block.NewUDiv(x, y), block
+	case parser.MODULO:
+		if exp.Datatype.IsSigned() {
+			return block.NewSRem(x, y), block
+		}
+		return block.NewURem(x, y), block
+	}
+	panic("?")
+}
+
+// generateLogical evaluates the right side of && and || only if the left side doesn't decide the result.
+func (b *LLVM) generateLogical(exp *parser.Node, block *ir.Block, cf *CompiledFunction) (value.Value, *ir.Block) {
+	left, block := b.generateCondition(exp.A, block, cf)
+
+	rightBlock := b.newBlock(block)
+	end := b.newBlock(block)
+	if exp.Type == parser.LOGICAL_AND {
+		block.NewCondBr(left, rightBlock, end)
+	} else {
+		block.NewCondBr(left, end, rightBlock)
+	}
+
+	right, rightBlock := b.generateCondition(exp.B, rightBlock, cf)
+	rightBlock.NewBr(end)
+
+	phi := end.NewPhi(ir.NewIncoming(left, block), ir.NewIncoming(right, rightBlock))
+	return end.NewZExt(phi, types.I64), end
+}
+
+// generateCondition compares the value of exp against zero.
+func (b *LLVM) generateCondition(exp *parser.Node, block *ir.Block, cf *CompiledFunction) (value.Value, *ir.Block) {
+	x, block := b.generateExpression(exp, block, cf)
+	if isFloat(x) {
+		return block.NewFCmp(enum.FPredUNE, x, constant.NewFloat(types.Double, 0)), block
+	}
+	return block.NewICmp(enum.IPredNE, x, constant.NewInt(types.I64, 0)), block
+}
+
+func (b *LLVM) generateFunctionCall(fc parser.FunctionCall, block *ir.Block, cf *CompiledFunction) (*ir.InstCall, *ir.Block) {
+	if fc.Callee != nil || b.isVariable(fc.Name, cf) {
+		return b.generateIndirectCall(fc, block, cf)
+	}
 	f := b.findFunction(fc.Name, cf)
 
-	if len(fc.Arguments) != len(f.Sig.Params) {
-		panic("Argument count mismatch in call to " + f.GlobalName)
+	if len(fc.Arguments) < len(f.Sig.Params) || (!f.Sig.Variadic && len(fc.Arguments) != len(f.Sig.Params)) {
+		b.error("Argument count mismatch in call to "+f.GlobalName, cf)
+	}
+
+	arguments := []value.Value{}
+	for i := range fc.Arguments {
+		var x value.Value
+		x, block = b.generateExpression(fc.Arguments[i], block, cf)
+		var t types.Type
+		if i < len(f.Sig.Params) {
+			t = f.Sig.Params[i]
+		} else {
+			t = b.promote(fc.Arguments[i].Datatype)
+		}
+		arguments = append(arguments, b.convert(x, fc.Arguments[i].Datatype.IsSigned(), t, block))
+	}
+
+	return block.NewCall(f, arguments...), block
+}
+
+// promote returns the type of an additional argument to a variadic function after the C default promotions:
+// integers smaller than i32 are passed as i32 and f32 as f64.
+func (b *LLVM) promote(d parser.UnnamedDatatype) types.Type {
+	switch t := b.datatypeToLLVM(d).(type) {
+	case *types.IntType:
+		if t.BitSize < 32 {
+			return types.I32
+		}
+		return t
+	case *types.FloatType:
+		return types.Double
+	default:
+		return t
 	}
+}
+
+// generateIndirectCall calls the function pointer stored in a variable or returned by fc.Callee.
+func (b *LLVM) generateIndirectCall(fc parser.FunctionCall, block *ir.Block, cf *CompiledFunction) (*ir.InstCall, *ir.Block) {
+	var callee value.Value
+	if fc.Callee != nil {
+		var x value.Value
+		x, block = b.generateExpression(fc.Callee, block, cf)
+		callee = b.convert(x, false, b.datatypeToLLVM(fc.Callee.Datatype), block)
+	} else {
+		v, t := b.findVariable(fc.Name, cf, false)
+		callee = block.NewLoad(t, v)
+	}
+	signature := callee.Type().(*types.PointerType).ElemType.(*types.FuncType)
 
 	arguments := []value.Value{}
 	for i := range fc.Arguments {
-		arguments = append(arguments, b.autoTypeCast(b.generateExpression(fc.Arguments[i], block, cf), f.Sig.Params[i], block))
+		var x value.Value
+		x, block = b.generateExpression(fc.Arguments[i], block, cf)
+		arguments = append(arguments, b.convert(x, fc.Arguments[i].Datatype.IsSigned(), signature.Params[i], block))
+	}
+
+	return block.NewCall(callee, arguments...), block
+}
+
+// convert is autoTypeCast for integers with known signedness, which decides between sext/zext and sitofp/uitofp.
+func (b *LLVM) convert(source value.Value, signed bool, target types.Type, block *ir.Block) value.Value {
+	sourceInt, ok := source.Type().(*types.IntType)
+	if !ok || source.Type().Equal(target) {
+		return b.autoTypeCast(source, target, block)
 	}
 
-	return block.NewCall(f, arguments...)
+	switch target := target.(type) {
+	case *types.IntType:
+		if target.BitSize > sourceInt.BitSize && signed {
+			return block.NewSExt(source, target)
+		}
+	case *types.FloatType:
+		if !signed {
+			return block.NewUIToFP(b.convert(source, false, types.I64, block), target)
+		}
+		return block.NewSIToFP(b.convert(source, true, types.I64, block), target)
+	}
+	return b.autoTypeCast(source, target, block)
 }
 
 func (b *LLVM) autoTypeCast(source value.Value, target types.Type, block *ir.Block) value.Value {
@@ -243,6 +675,19 @@ func (b *LLVM) autoTypeCast(source value.Value, target types.Type, block *ir.Blo
 		return source
 	}
 
+	sourceFloat, sourceIsFloat := source.Type().(*types.FloatType)
+	targetFloat, targetIsFloat := target.(*types.FloatType)
+	if sourceIsFloat && targetIsFloat {
+		if floatSize(targetFloat) > floatSize(sourceFloat) {
+			return block.NewFPExt(source, target)
+		}
+		return block.NewFPTrunc(source, target)
+	} else if sourceIsFloat {
+		return b.autoTypeCast(block.NewFPToSI(source, types.I64), target, block)
+	} else if targetIsFloat {
+		return block.NewSIToFP(b.autoTypeCast(source, types.I64, block), target)
+	}
+
 	if _, ok := source.Type().(*types.PointerType); ok {
 		return block.NewPtrToInt(source, target)
 	} else {
@@ -257,20 +702,36 @@ func (b *LLVM) autoTypeCast(source value.Value, target types.Type, block *ir.Blo
 	}
 }
 
+func floatSize(t *types.FloatType) int {
+	switch t.Kind {
+	case types.FloatKindHalf:
+		return 2
+	case types.FloatKindFloat:
+		return 4
+	case types.FloatKindDouble:
+		return 8
+	default:
+		return 16
+	}
+}
+
 func (b *LLVM) newBlock(block *ir.Block) *ir.Block {
 	new := block.Parent.NewBlock("")
 	return new
 }
 
-func (b *LLVM) generateVariableSelfModify(name string, operation parser.NodeType) []*parser.Node {
+func (b *LLVM) generateVariableSelfModify(node *parser.Node, operation parser.NodeType) []*parser.Node {
+	name := node.Value.(string)
 	return []*parser.Node{
 		{
 			Type: parser.VARIABLE_ASSIGN,
 			A: &parser.Node{
-				Type: operation,
+				Type:     operation,
+				Datatype: node.Datatype,
 				A: &parser.Node{
-					Type:  parser.VARIABLE_LOOKUP,
-					Value: name,
+					Type:     parser.VARIABLE_LOOKUP,
+					Value:    name,
+					Datatype: node.Datatype,
 				},
 				B: &parser.Node{
 					Type:  parser.NUMBER,
@@ -287,8 +748,7 @@ func (b *LLVM) generateIf(block *ir.Block, node *parser.Node, iff parser.If, cf
 	ifFalse := b.newBlock(block)
 	ifAfter := b.newBlock(block)
 
-	x := b.generateExpression(node.A, block, cf)
-	cmp := block.NewICmp(enum.IPredNE, x, constant.NewInt(types.I64, 0))
+	cmp, block := b.generateCondition(node.A, block, cf)
 	block.NewCondBr(cmp, ifTrue, ifFalse)
 
 	ifTrue = b.generateCodeBlock(ifTrue, iff.TrueBlock, cf)
@@ -304,119 +764,243 @@ func (b *LLVM) generateIf(block *ir.Block, node *parser.Node, iff parser.If, cf
 	return ifAfter
 }
 
+// generateSwitch lowers a switch statement to a LLVM switch, cases don't fall through.
+func (b *LLVM) generateSwitch(block *ir.Block, node *parser.Node, cf *CompiledFunction) *ir.Block {
+	s := node.Value.(parser.Switch)
+	switchAfter := b.newBlock(block)
+
+	x, block := b.generateExpression(node.A, block, cf)
+	x = b.convert(x, node.A.Datatype.IsSigned(), types.I64, block)
+
+	cases := []*ir.Case{}
+	for _, cs := range s.Cases {
+		caseBlock := b.newBlock(block)
+		for _, v := range cs.Values {
+			value, err := constexpr.Evaluate(v)
+			if err != nil {
+				b.error(err.Error(), cf)
+			}
+			cases = append(cases, ir.NewCase(constant.NewInt(types.I64, int64(value)), caseBlock))
+		}
+
+		caseBlock = b.generateCodeBlock(caseBlock, cs.Body, cf)
+		if caseBlock.Term == nil {
+			caseBlock.NewBr(switchAfter)
+		}
+	}
+
+	defaultBlock := switchAfter
+	if s.Default != nil {
+		defaultBlock = b.newBlock(block)
+		end := b.generateCodeBlock(defaultBlock, s.Default, cf)
+		if end.Term == nil {
+			end.NewBr(switchAfter)
+		}
+	}
+
+	block.NewSwitch(x, defaultBlock, cases...)
+	return switchAfter
+}
+
+// generateLoopBody generates the body of a loop with continueBlock and breakBlock as targets for continue and break.
+func (b *LLVM) generateLoopBody(block *ir.Block, loop parser.Loop, continueBlock *ir.Block, breakBlock *ir.Block, cf *CompiledFunction) {
+	cf.loops = append(cf.loops, loopTarget{label: loop.Label, continueBlock: continueBlock, breakBlock: breakBlock})
+	block = b.generateCodeBlock(block, loop.Body, cf)
+	cf.loops = cf.loops[:len(cf.loops)-1]
+
+	if block.Term == nil {
+		block.NewBr(continueBlock)
+	}
+}
+
 func (b *LLVM) generateConditionalLoop(block *ir.Block, node *parser.Node, cf *CompiledFunction) *ir.Block {
+	loop := node.Value.(parser.Loop)
+	if loop.Init != nil {
+		cf.pushScope()
+		defer cf.popScope()
+		block = b.generateStatements(block, []*parser.Node{loop.Init}, cf)
+	}
 	loopCompare := b.newBlock(block)
 	loopBody := b.newBlock(block)
 	loopEnd := b.newBlock(block)
 
 	block.NewBr(loopCompare)
 
-	x := b.generateExpression(node.A, loopCompare, cf)
-	cmp := loopCompare.NewICmp(enum.IPredNE, x, constant.NewInt(types.I64, 0))
-	loopCompare.NewCondBr(cmp, loopBody, loopEnd)
+	cmp, compareEnd := b.generateCondition(node.A, loopCompare, cf)
+	compareEnd.NewCondBr(cmp, loopBody, loopEnd)
+
+	if loop.Update != nil {
+		// continue has to run the update of for loops
+		loopUpdate := b.newBlock(block)
+		b.generateLoopBody(loopBody, loop, loopUpdate, loopEnd, cf)
 
-	loopBody = b.generateCodeBlock(loopBody, node.Value.([]*parser.Node), cf)
-	if loopBody.Term == nil {
-		loopBody.NewBr(loopCompare)
+		loopUpdate = b.generateCodeBlock(loopUpdate, []*parser.Node{loop.Update}, cf)
+		loopUpdate.NewBr(loopCompare)
+	} else {
+		b.generateLoopBody(loopBody, loop, loopCompare, loopEnd, cf)
 	}
 
 	return loopEnd
 }
 
 func (b *LLVM) generatePostConditionalLoop(block *ir.Block, node *parser.Node, cf *CompiledFunction) *ir.Block {
+	loop := node.Value.(parser.Loop)
 	loopBody := b.newBlock(block)
+	loopCompare := b.newBlock(block)
 	loopEnd := b.newBlock(block)
 
 	block.NewBr(loopBody)
 
-	loopBody = b.generateCodeBlock(loopBody, node.Value.([]*parser.Node), cf)
+	b.generateLoopBody(loopBody, loop, loopCompare, loopEnd, cf)
 
-	x := b.generateExpression(node.A, loopBody, cf)
-	cmp := loopBody.NewICmp(enum.IPredNE, x, constant.NewInt(types.I64, 0))
-	if loopBody.Term == nil {
-		loopBody.NewCondBr(cmp, loopBody, loopEnd)
-	}
+	cmp, compareEnd := b.generateCondition(node.A, loopCompare, cf)
+	compareEnd.NewCondBr(cmp, loopBody, loopEnd)
 
 	return loopEnd
 }
 
+// generateCodeBlock generates body in a new scope.
 func (b *LLVM) generateCodeBlock(block *ir.Block, body []*parser.Node, cf *CompiledFunction) *ir.Block {
+	cf.pushScope()
+	block = b.generateStatements(block, body, cf)
+	cf.popScope()
+	return block
+}
+
+// generateEndExec generates the end blocks which were reached, in the order they were declared.
+func (b *LLVM) generateEndExec(block *ir.Block, cf *CompiledFunction) *ir.Block {
+	scopes := cf.scopes
+	for _, end := range cf.endExec {
+		endTrue := b.newBlock(block)
+		endAfter := b.newBlock(block)
 
+		hit := block.NewLoad(types.I64, end.hit)
+		block.NewCondBr(block.NewICmp(enum.IPredNE, hit, constant.NewInt(types.I64, 0)), endTrue, endAfter)
+
+		cf.scopes = end.scopes
+		endTrue = b.generateCodeBlock(endTrue, end.body, cf)
+		if endTrue.Term == nil {
+			endTrue.NewBr(endAfter)
+		}
+		block = endAfter
+	}
+	cf.scopes = scopes
+	return block
+}
+
+func (b *LLVM) generateStatements(block *ir.Block, body []*parser.Node, cf *CompiledFunction) *ir.Block {
 	for i := range body {
 		node := body[i]
+		b.at(node)
+
+		// expressions may end in a different block, so values are assigned together with the block
+		var x value.Value
 
 		switch node.Type {
 		case parser.VARIABLE_DECLARATION:
 			datatype := node.Value.(parser.NamedDatatype)
-			v := block.NewAlloca(b.datatypeToLLVM(datatype.UnnamedDatatype))
-			v.SetName("local_" + datatype.Name)
-			cf.variables[datatype.Name] = v
+			v := cf.declare(datatype.Name, "local_", b.datatypeToLLVM(datatype.UnnamedDatatype))
 
-			if node.A != nil {
-				x := b.generateExpression(node.A, block, cf)
-				c := b.autoTypeCast(x, v.ElemType, block)
+			if datatype.Length > 0 {
+				// the variable points to the storage of the fixed size array like any other array
+				elements := []*parser.Node{}
+				if node.A != nil {
+					elements = node.A.Value.([]*parser.Node)
+				}
+				x, block = b.generateArrayLiteral(elements, datatype.Length, node.A != nil, v.ElemType.(*types.PointerType), block, cf)
+				block.NewStore(x, v)
+			} else if node.A != nil && node.A.Type == parser.ARRAY_LITERAL {
+				elements := node.A.Value.([]*parser.Node)
+				x, block = b.generateArrayLiteral(elements, len(elements), false, v.ElemType.(*types.PointerType), block, cf)
+				block.NewStore(x, v)
+			} else if node.A != nil {
+				x, block = b.generateExpression(node.A, block, cf)
+				c := b.convert(x, node.A.Datatype.IsSigned(), v.ElemType, block)
 				block.NewStore(c, v)
 			}
 		case parser.VARIABLE_ASSIGN:
 			v, t := b.findVariable(node.Value.(string), cf, true)
-			x := b.generateExpression(node.A, block, cf)
-			c := b.autoTypeCast(x, t, block)
+			x, block = b.generateExpression(node.A, block, cf)
+			c := b.convert(x, node.A.Datatype.IsSigned(), t, block)
 			block.NewStore(c, v)
 		case parser.VARIABLE_ASSIGN_ARRAY:
 			v, t := b.findVariable(node.Value.(string), cf, true)
+			var i value.Value
+			i, block = b.generateExpression(node.A, block, cf)
+			x, block = b.generateExpression(node.B, block, cf)
 			ptr := block.NewLoad(t, v)
-			i := b.generateExpression(node.A, block, cf)
 			indexed := block.NewGetElementPtr(ptr.ElemType.(*types.PointerType).ElemType, ptr, i)
-			x := b.generateExpression(node.B, block, cf)
-			c := b.autoTypeCast(x, ptr.ElemType.(*types.PointerType).ElemType, block)
+			c := b.convert(x, node.B.Datatype.IsSigned(), ptr.ElemType.(*types.PointerType).ElemType, block)
 			block.NewStore(c, indexed)
+		case parser.FIELD_ASSIGN:
+			var ptr value.Value
+			ptr, block = b.generateFieldPointer(node.A, block, cf)
+			x, block = b.generateExpression(node.B, block, cf)
+			c := b.convert(x, node.B.Datatype.IsSigned(), ptr.Type().(*types.PointerType).ElemType, block)
+			block.NewStore(c, ptr)
+		case parser.DEREFERENCE_ASSIGN:
+			var ptr value.Value
+			ptr, block = b.generateTypedPointer(node.A.A, block, cf)
+			x, block = b.generateExpression(node.B, block, cf)
+			c := b.convert(x, node.B.Datatype.IsSigned(), ptr.Type().(*types.PointerType).ElemType, block)
+			block.NewStore(c, ptr)
 		case parser.VARIABLE_INCREASE:
-			block = b.generateCodeBlock(block, b.generateVariableSelfModify(node.Value.(string), parser.ADD), cf)
+			block = b.generateCodeBlock(block, b.generateVariableSelfModify(node, parser.ADD), cf)
 		case parser.VARIABLE_DECREASE:
-			block = b.generateCodeBlock(block, b.generateVariableSelfModify(node.Value.(string), parser.SUBTRACT), cf)
+			block = b.generateCodeBlock(block, b.generateVariableSelfModify(node, parser.SUBTRACT), cf)
 		case parser.FUNCTION_CALL:
 			fc := node.Value.(parser.FunctionCall)
-			b.generateFunctionCall(fc, block, cf)
+			_, block = b.generateFunctionCall(fc, block, cf)
+		case parser.INLINE_ASSEMBLY:
+			_, block = b.generateExpression(node, block, cf)
 		case parser.RETURN:
 			if block.Term != nil {
 				b.error("Block already terminated", cf)
 			}
 
 			if node.A != nil {
-				x := b.generateExpression(node.A, block, cf)
-				c := b.autoTypeCast(x, cf.returnType, block)
+				x, block = b.generateExpression(node.A, block, cf)
+				c := b.convert(x, node.A.Datatype.IsSigned(), cf.returnType, block)
 				cf.returnIncomings = append(cf.returnIncomings, ir.NewIncoming(c, block))
 			}
 			block.NewBr(cf.returnBlock)
 
 		case parser.IF:
 			block = b.generateIf(block, node, node.Value.(parser.If), cf)
+		case parser.SWITCH:
+			block = b.generateSwitch(block, node, cf)
 		case parser.CONDITIONAL_LOOP:
 			block = b.generateConditionalLoop(block, node, cf)
 		case parser.POST_CONDITIONAL_LOOP:
 			block = b.generatePostConditionalLoop(block, node, cf)
 		case parser.LOOP:
 			loopBody := b.newBlock(block)
+			loopEnd := b.newBlock(block)
 			block.NewBr(loopBody)
 
-			loopEnd := b.generateCodeBlock(loopBody, node.Value.([]*parser.Node), cf)
-			if loopEnd.Term == nil {
-				loopEnd.NewBr(loopBody)
+			b.generateLoopBody(loopBody, node.Value.(parser.Loop), loopBody, loopEnd, cf)
+
+			block = loopEnd
+		case parser.BREAK, parser.CONTINUE:
+			loop := cf.findLoop(node.Value.(string), b.error)
+			if node.Type == parser.BREAK {
+				block.NewBr(loop.breakBlock)
+			} else {
+				block.NewBr(loop.continueBlock)
 			}
 
+			// code after break or continue is unreachable but still needs a block
 			block = b.newBlock(block)
 		case parser.END_EXEC:
 			hit := cf.entryBlock.NewAlloca(types.I64)
-			endId := "end_" + strconv.Itoa(cf.endId)
-			hit.SetName(endId)
+			hit.SetName("end_" + strconv.Itoa(cf.endId))
 			cf.endId++
 			cf.entryBlock.NewStore(constant.NewInt(types.I64, 0), hit)
-			cf.variables[endId] = hit
 
 			block.NewStore(constant.NewInt(types.I64, 1), hit)
-			cf.endExec = append(cf.endExec, parser.NewNode(parser.IF, parser.NewNode(parser.VARIABLE_LOOKUP, nil, nil, endId), nil, parser.If{
-				TrueBlock: node.Value.([]*parser.Node),
-			}))
+			// the end block sees the variables of the scope it was declared in
+			scopes := append([]map[string]*ir.InstAlloca{}, cf.scopes...)
+			cf.endExec = append(cf.endExec, endExec{hit: hit, body: node.Value.([]*parser.Node), scopes: scopes})
 		default:
 			panic("Unknown " + strconv.Itoa(int(node.Type)))
 		}
@@ -426,20 +1010,22 @@ func (b *LLVM) generateCodeBlock(block *ir.Block, body []*parser.Node, cf *Compi
 
 func (b *LLVM) generateFunction(f *ir.Func, af parser.Function) *CompiledFunction {
 	cf := CompiledFunction{
-		variables:       make(map[string]*ir.InstAlloca),
+		scopes:          []map[string]*ir.InstAlloca{},
+		declared:        make(map[string]int),
 		returnBlock:     nil,
 		returnIncomings: []*ir.Incoming{},
 		returnType:      f.Sig.RetType,
 		name:            af.Name,
 		endId:           0,
-		endExec:         []*parser.Node{},
+		endExec:         []endExec{},
 	}
 
 	declareOnly := false
 	noReturn := false
 
 	if utils.IndexOf(af.Attributes, parser.Assembly) >= 0 {
-		b.error("Unsupported attribute assembly", &cf)
+		b.generateAssemblyFunction(f, af, &cf)
+		return &cf
 	} else if utils.IndexOf(af.Attributes, parser.NoReturn) >= 0 {
 		noReturn = true
 	} else if utils.IndexOf(af.Attributes, parser.External) >= 0 {
@@ -452,16 +1038,15 @@ func (b *LLVM) generateFunction(f *ir.Func, af parser.Function) *CompiledFunctio
 		entry := f.NewBlock("entry")
 		main := f.NewBlock("body")
 
+		cf.entryBlock = entry
+		cf.pushScope()
+
 		for i := range af.Arguments {
 			argument := af.Arguments[i]
-			v := entry.NewAlloca(b.datatypeToLLVM(argument.UnnamedDatatype))
-			v.SetName("arg_" + argument.Name)
-			cf.variables[argument.Name] = v
+			v := cf.declare(argument.Name, "arg_", b.datatypeToLLVM(argument.UnnamedDatatype))
 			entry.NewStore(f.Params[i], v)
 		}
 
-		cf.entryBlock = entry
-
 		entry.NewBr(main)
 
 		ret := f.NewBlock("return")
@@ -474,18 +1059,18 @@ func (b *LLVM) generateFunction(f *ir.Func, af parser.Function) *CompiledFunctio
 		if main.Term == nil {
 			if f.Sig.RetType.Equal(types.Void) {
 				main.NewBr(ret)
-				ret = b.generateCodeBlock(ret, cf.endExec, &cf)
+				ret = b.generateEndExec(ret, &cf)
 				ret.NewRet(nil)
 			} else {
 				// fmt.Println("[WARNING] no return in non void function")
-				x := b.generateExpression(parser.NewNode(parser.NUMBER, nil, nil, 0), main, &cf)
+				x, _ := b.generateExpression(parser.NewNode(parser.NUMBER, nil, nil, 0), main, &cf)
 				c := b.autoTypeCast(x, cf.returnType, main)
 				cf.returnIncomings = append(cf.returnIncomings, ir.NewIncoming(c, main))
 				main.NewBr(ret)
 			}
 		}
 		if noReturn {
-			ret = b.generateCodeBlock(ret, cf.endExec, &cf)
+			ret = b.generateEndExec(ret, &cf)
 			b.generateFunctionCall(parser.FunctionCall{
 				Name:      "unreachable",
 				Arguments: []*parser.Node{},
@@ -493,13 +1078,13 @@ func (b *LLVM) generateFunction(f *ir.Func, af parser.Function) *CompiledFunctio
 		} else {
 			if len(cf.returnIncomings) > 0 {
 				phi := ret.NewPhi(cf.returnIncomings...)
-				ret = b.generateCodeBlock(ret, cf.endExec, &cf)
+				ret = b.generateEndExec(ret, &cf)
 				ret.NewRet(phi)
 			}
 		}
 	}
 
-	// fmt.Println("[DEBUG]", f.Name(), "compiled with", len(f.Sig.Params), "arguments and", len(cf.variables), "local variables")
+	// fmt.Println("[DEBUG]", f.Name(), "compiled with", len(f.Sig.Params), "arguments and", len(cf.declared), "local variables")
 
 	return &cf
 }
@@ -511,73 +1096,332 @@ func (b *LLVM) generateFunctionDeclaration(f parser.Function, module *ir.Module)
 		parameters = append(parameters, ir.NewParam(f.Arguments[i].Name, b.datatypeToLLVM(f.Arguments[i].UnnamedDatatype)))
 	}
 
-	b.functions[f.Name] = module.NewFunc(f.Name, b.datatypeToLLVM(f.ReturnDatatype), parameters...)
+	function := module.NewFunc(f.Name, b.datatypeToLLVM(f.ReturnDatatype), parameters...)
+	function.Sig.Variadic = f.Variadic
+	// main is the entry point and has to be visible to the linker even without the global attribute,
+	// functions of other units are plain declarations
+	if utils.IndexOf(f.Attributes, parser.External) == -1 && f.Name != "main" && b.owns(f.Name) {
+		b.setVisibility(function, &function.Linkage, f.Attributes)
+	}
+	b.functions[f.Name] = function
 
 }
 
-func (b *LLVM) generateOffset(offset parser.Offset, module *ir.Module) {
-	current := 0
+// owns returns true if the function or global variable name is defined in the current unit.
+func (b *LLVM) owns(name string) bool {
+	unit, ok := b.owner[name]
+	return !ok || unit == b.unit
+}
+
+// setVisibility gives definitions without the global attribute internal linkage, so LLVM may inline and
+// drop them and they don't clash with symbols of other object files. keep retains them through @llvm.used.
+func (b *LLVM) setVisibility(definition constant.Constant, linkage *enum.Linkage, attributes []parser.FunctionAttribute) {
+	if utils.IndexOf(attributes, parser.Global) == -1 {
+		*linkage = enum.LinkageInternal
+	}
+	if utils.IndexOf(attributes, parser.Keep) != -1 {
+		b.used = append(b.used, definition)
+	}
+}
+
+// generateUsed emits @llvm.used with all definitions marked keep.
+func (b *LLVM) generateUsed() {
+	if len(b.used) == 0 {
+		return
+	}
+
+	elements := []constant.Constant{}
+	for _, definition := range b.used {
+		elements = append(elements, constant.NewBitCast(definition, types.I8Ptr))
+	}
+	used := b.module.NewGlobalDef("llvm.used", constant.NewArray(types.NewArray(uint64(len(elements)), types.I8Ptr), elements...))
+	used.Linkage = enum.LinkageAppending
+	used.Section = "llvm.metadata"
+}
 
+// Layout returns the offset and size of every entry of offset and the total size, entries are packed without padding.
+func (b *LLVM) Layout(offset parser.Offset) (offsets []int, sizes []int, size int) {
 	for _, entry := range offset.Entries {
-		size := b.datatypeToSize(entry.UnnamedDatatype)
+		offsets = append(offsets, size)
+		sizes = append(sizes, b.datatypeToSize(entry.UnnamedDatatype))
+		size += sizes[len(sizes)-1]
+	}
+	return offsets, sizes, size
+}
+
+func (b *LLVM) generateOffset(offset parser.Offset, module *ir.Module) {
+	offsets, _, size := b.Layout(offset)
+
+	for i, entry := range offset.Entries {
 		name := offset.Name + "_" + entry.Name
-		x := module.NewGlobalDef(name, constant.NewInt(types.I64, int64(current)))
-		b.globalVariables[name] = GlobalVariable{varivable: x, final: true}
-		current += size
+		x := module.NewGlobalDef(name, constant.NewInt(types.I64, int64(offsets[i])))
+		x.Linkage = enum.LinkageInternal
+		x.Immutable = true
+		b.globalVariables[name] = GlobalVariable{varivable: x, final: true, pos: b.pos}
 	}
 
 	name := offset.Name + "_size"
-	x := module.NewGlobalDef(name, constant.NewInt(types.I64, int64(current)))
-	b.globalVariables[name] = GlobalVariable{varivable: x, final: true}
+	x := module.NewGlobalDef(name, constant.NewInt(types.I64, int64(size)))
+	x.Linkage = enum.LinkageInternal
+	x.Immutable = true
+	b.globalVariables[name] = GlobalVariable{varivable: x, final: true, pos: b.pos}
 }
 
-func (b *LLVM) Compile() string {
-	tmp := b.global.Value.([]*parser.Node)
+// generateStructs creates the named LLVM types first so fields can reference any struct.
+func (b *LLVM) generateStructs(global []*parser.Node) {
+	for _, node := range global {
+		if node.Type == parser.STRUCT_DECLARATION {
+			s := node.Value.(parser.Offset)
+			structType := types.NewStruct()
+			structType.Packed = true
+			b.module.NewTypeDef(s.Name, structType)
+			b.structs[s.Name] = structType
+			b.structFields[s.Name] = s.Entries
+		}
+	}
 
-	b.module = ir.NewModule()
-	b.module.TargetTriple = b.target
+	for _, node := range global {
+		if node.Type == parser.STRUCT_DECLARATION {
+			s := node.Value.(parser.Offset)
+			for _, entry := range s.Entries {
+				b.structs[s.Name].Fields = append(b.structs[s.Name].Fields, b.datatypeToLLVM(entry.UnnamedDatatype))
+			}
+		}
+	}
+}
 
-	for i := range tmp {
-		switch tmp[i].Type {
-		case parser.VARIABLE_DECLARATION:
-			datatype := tmp[i].Value.(parser.NamedDatatype)
-			d := b.datatypeToLLVM(datatype.UnnamedDatatype)
+func (b *LLVM) newFloat(t *types.FloatType, v float64) *constant.Float {
+	if t.Kind == types.FloatKindFloat {
+		// round so the constant is exactly representable as float
+		v = float64(float32(v))
+	}
+	return constant.NewFloat(t, v)
+}
 
-			var global *ir.Global
+// generateConstantArray emits the constant elements of an array literal as writable global and returns a pointer
+// to it. The global array may be changed through any pointer to it, so it can't be read only.
+func (b *LLVM) generateConstantArray(name string, literal *parser.Node, array *types.PointerType) constant.Constant {
+	elements := literal.Value.([]*parser.Node)
+	values := []constant.Constant{}
+	for _, element := range elements {
+		b.at(element)
+		if element.Type == parser.STRING {
+			var s constant.Constant = b.newGlobalString(element.Value.(string)).(constant.Constant)
+			if !s.Type().Equal(array.ElemType) {
+				s = constant.NewBitCast(s, array.ElemType)
+			}
+			values = append(values, s)
+			continue
+		}
 
-			if tmp[i].A != nil {
-				if datatype.IsArray {
-					panic("Global array initializers not supported!")
+		switch t := array.ElemType.(type) {
+		case *types.FloatType:
+			value, err := constexpr.EvaluateFloat(element)
+			if err != nil {
+				b.error(err.Error(), nil)
+			}
+			values = append(values, b.newFloat(t, value))
+		default:
+			value, err := constexpr.Evaluate(element)
+			if err != nil {
+				b.error(err.Error(), nil)
+			}
+			if inttype, ok := t.(*types.IntType); ok {
+				values = append(values, constant.NewInt(inttype, int64(value)))
+			} else {
+				values = append(values, constant.NewIntToPtr(constant.NewInt(types.I64, int64(value)), t))
+			}
+		}
+	}
+
+	content := constant.NewArray(types.NewArray(uint64(len(values)), array.ElemType), values...)
+	global := b.module.NewGlobalDef(name+".init", content)
+	global.Linkage = enum.LinkagePrivate
+
+	zero := constant.NewInt(types.I64, 0)
+	return constant.NewGetElementPtr(content.Typ, global, zero, zero)
+}
+
+// generateArrayLiteral stores the elements of an array literal into stack memory of length elements, which lives
+// until the function returns. If clear is set the memory is zeroed first, so elements after the literal are 0.
+func (b *LLVM) generateArrayLiteral(elements []*parser.Node, length int, clear bool, array *types.PointerType, block *ir.Block, cf *CompiledFunction) (value.Value, *ir.Block) {
+	t := types.NewArray(uint64(length), array.ElemType)
+	storage := cf.entryBlock.NewAlloca(t)
+	if clear && len(elements) < length {
+		block.NewStore(constant.NewZeroInitializer(t), storage)
+	}
+
+	zero := constant.NewInt(types.I64, 0)
+	for i, element := range elements {
+		var x value.Value
+		x, block = b.generateExpression(element, block, cf)
+		ptr := block.NewGetElementPtr(t, storage, zero, constant.NewInt(types.I64, int64(i)))
+		block.NewStore(b.convert(x, element.Datatype.IsSigned(), array.ElemType, block), ptr)
+	}
+	return block.NewGetElementPtr(t, storage, zero, zero), block
+}
+
+func (b *LLVM) generateGlobalVariable(node *parser.Node) {
+	datatype := node.Value.(parser.GlobalVariable)
+	d := b.datatypeToLLVM(datatype.UnnamedDatatype)
+
+	var global *ir.Global
+
+	if node.A != nil {
+		if node.A.Type == parser.ARRAY_LITERAL {
+			global = b.module.NewGlobalDef(datatype.Name, b.generateConstantArray(datatype.Name, node.A, d.(*types.PointerType)))
+		} else if datatype.IsArray {
+			b.error("Global arrays can only be initialized with an array literal", nil)
+		} else if node.A.Type == parser.STRING {
+			s := b.module.NewGlobalDef(datatype.Name+".init", constant.NewCharArrayFromString(node.A.Value.(string)+"\x00"))
+			s.Linkage = enum.LinkagePrivate
+			global = b.module.NewGlobalDef(datatype.Name, constant.NewIntToPtr(constant.NewPtrToInt(s, types.I64), d))
+		} else if node.A.Type == parser.VARIABLE_LOOKUP && b.functions[node.A.Value.(string)] != nil {
+			// address of a function
+			f := b.functions[node.A.Value.(string)]
+			b.reference(node.A.Value.(string))
+			if inttype, ok := d.(*types.IntType); ok {
+				global = b.module.NewGlobalDef(datatype.Name, constant.NewPtrToInt(f, inttype))
+			} else {
+				global = b.module.NewGlobalDef(datatype.Name, constant.NewBitCast(f, d))
+			}
+		} else {
+			if inttype, ok := d.(*types.IntType); ok {
+				value, err := constexpr.Evaluate(node.A)
+				if err != nil {
+					b.error(err.Error(), nil)
 				}
-				if tmp[i].A.Type == parser.STRING {
-					s := b.module.NewGlobalDef(datatype.Name+".init", constant.NewCharArrayFromString(tmp[i].A.Value.(string)+"\x00"))
-					global = b.module.NewGlobalDef(datatype.Name, constant.NewIntToPtr(constant.NewPtrToInt(s, types.I64), d))
-				} else {
-					if inttype, ok := d.(*types.IntType); ok {
-						global = b.module.NewGlobalDef(datatype.Name, constant.NewInt(inttype, int64(constexpr.Evaluate(tmp[i].A))))
-					} else {
-						panic("Expected int type when using constant expression")
-					}
+				global = b.module.NewGlobalDef(datatype.Name, constant.NewInt(inttype, int64(value)))
+			} else if floattype, ok := d.(*types.FloatType); ok {
+				value, err := constexpr.EvaluateFloat(node.A)
+				if err != nil {
+					b.error(err.Error(), nil)
 				}
-			} else {
-				switch d := d.(type) {
-				case *types.PointerType:
-					global = b.module.NewGlobalDef(datatype.Name, constant.NewIntToPtr(constant.NewInt(types.I64, 0), d))
-				case *types.IntType:
-					global = b.module.NewGlobalDef(datatype.Name, constant.NewInt(d, 0))
-				default:
-					panic("?")
+				global = b.module.NewGlobalDef(datatype.Name, b.newFloat(floattype, value))
+			} else if pointertype, ok := d.(*types.PointerType); ok && datatype.Pointer > 0 {
+				value, err := constexpr.Evaluate(node.A)
+				if err != nil {
+					b.error(err.Error(), nil)
 				}
+				global = b.module.NewGlobalDef(datatype.Name, constant.NewIntToPtr(constant.NewInt(types.I64, int64(value)), pointertype))
+			} else {
+				b.error("Expected int type when using constant expression", nil)
 			}
+		}
+	} else {
+		switch d := d.(type) {
+		case *types.PointerType:
+			global = b.module.NewGlobalDef(datatype.Name, constant.NewIntToPtr(constant.NewInt(types.I64, 0), d))
+		case *types.IntType:
+			global = b.module.NewGlobalDef(datatype.Name, constant.NewInt(d, 0))
+		case *types.FloatType:
+			global = b.module.NewGlobalDef(datatype.Name, b.newFloat(d, 0))
+		default:
+			panic("?")
+		}
+	}
 
-			b.globalVariables[datatype.Name] = GlobalVariable{varivable: global, final: false}
+	b.setVisibility(global, &global.Linkage, datatype.Attributes)
+	b.globalVariables[datatype.Name] = GlobalVariable{varivable: global, final: false, pos: node.Pos}
+}
 
-		case parser.OFFSET:
-			b.generateOffset(tmp[i].Value.(parser.Offset), b.module)
+// generateGlobalDeclaration declares a global variable defined in another unit.
+func (b *LLVM) generateGlobalDeclaration(node *parser.Node) {
+	datatype := node.Value.(parser.GlobalVariable)
+	global := b.module.NewGlobal(datatype.Name, b.datatypeToLLVM(datatype.UnnamedDatatype))
+	global.Linkage = enum.LinkageExternal
+	b.globalVariables[datatype.Name] = GlobalVariable{varivable: global, final: false, pos: node.Pos}
+}
+
+// Compile compiles the whole program into a single module.
+func (b *LLVM) Compile() string {
+	return b.CompileUnits([]Unit{{Nodes: b.global.Value.([]*parser.Node)}})[0]
+}
+
+// CompileUnits compiles every unit into its own module. Definitions used by other units get external linkage
+// with hidden visibility, so the objects can be linked into one executable.
+func (b *LLVM) CompileUnits(units []Unit) []string {
+	for i, unit := range units {
+		for _, node := range unit.Nodes {
+			switch node.Type {
+			case parser.FUNCTION:
+				b.owner[node.Value.(parser.Function).Name] = i
+			case parser.VARIABLE_DECLARATION:
+				b.owner[node.Value.(parser.GlobalVariable).Name] = i
+			}
+		}
+	}
+
+	modules := []*ir.Module{}
+	referenced := []map[string]bool{}
+	exported := map[string]bool{}
+	for i := range units {
+		b.unit = i
+		b.referenced = map[string]bool{}
+		b.compileUnit()
+		modules = append(modules, b.module)
+		referenced = append(referenced, b.referenced)
+		for name := range b.referenced {
+			exported[name] = true
+		}
+	}
+
+	results := make([]string, len(units))
+	if b.reporter.HasErrors() {
+		// the modules are incomplete
+		return results
+	}
+
+	for i, module := range modules {
+		funcs := []*ir.Func{}
+		for _, f := range module.Funcs {
+			if len(f.Blocks) == 0 && b.owner[f.Name()] != i && !referenced[i][f.Name()] {
+				continue
+			}
+			if len(f.Blocks) > 0 && exported[f.Name()] && f.Linkage == enum.LinkageInternal {
+				f.Linkage = enum.LinkageNone
+				f.Visibility = enum.VisibilityHidden
+			}
+			funcs = append(funcs, f)
+		}
+		module.Funcs = funcs
+
+		globals := []*ir.Global{}
+		for _, g := range module.Globals {
+			if g.Init == nil && !referenced[i][g.Name()] {
+				continue
+			}
+			if g.Init != nil && exported[g.Name()] && g.Linkage == enum.LinkageInternal {
+				g.Linkage = enum.LinkageNone
+				g.Visibility = enum.VisibilityHidden
+			}
+			globals = append(globals, g)
 		}
+		module.Globals = globals
+
+		results[i] = module.String()
 	}
+	return results
+}
 
+// compileUnit generates the module of the current unit. Structs, offsets and declarations of all functions
+// are generated for every unit, only the functions and global variables owned by the unit are defined.
+func (b *LLVM) compileUnit() {
+	tmp := b.global.Value.([]*parser.Node)
+
+	b.module = ir.NewModule()
+	b.module.TargetTriple = b.target
+	b.globalVariables = make(map[string]GlobalVariable)
+	b.functions = make(map[string]*ir.Func)
+	b.structs = make(map[string]*types.StructType)
+	b.structFields = make(map[string][]parser.NamedDatatype)
+	b.used = nil
+
+	b.generateStructs(tmp)
+
+	// functions are declared first, so global variables can be initialized with their address
 	for i := range tmp {
+		b.at(tmp[i])
 		switch tmp[i].Type {
 		case parser.FUNCTION:
 			b.generateFunctionDeclaration(tmp[i].Value.(parser.Function), b.module)
@@ -585,11 +1429,33 @@ func (b *LLVM) Compile() string {
 	}
 
 	for i := range tmp {
+		b.at(tmp[i])
+		switch tmp[i].Type {
+		case parser.VARIABLE_DECLARATION:
+			b.recover(func() {
+				if b.owns(tmp[i].Value.(parser.GlobalVariable).Name) {
+					b.generateGlobalVariable(tmp[i])
+				} else {
+					b.generateGlobalDeclaration(tmp[i])
+				}
+			})
+		case parser.OFFSET, parser.STRUCT_DECLARATION:
+			b.generateOffset(tmp[i].Value.(parser.Offset), b.module)
+		}
+	}
+
+	for i := range tmp {
+		b.at(tmp[i])
 		switch tmp[i].Type {
 		case parser.FUNCTION:
-			b.generateFunction(b.findFunction(tmp[i].Value.(parser.Function).Name, nil), tmp[i].Value.(parser.Function))
+			if !b.owns(tmp[i].Value.(parser.Function).Name) {
+				continue
+			}
+			b.recover(func() {
+				b.generateFunction(b.findFunction(tmp[i].Value.(parser.Function).Name, nil), tmp[i].Value.(parser.Function))
+			})
 		}
 	}
 
-	return b.module.String()
+	b.generateUsed()
 }
@@ -1,28 +1,83 @@
 package llvm
 
 import (
+	"fire/firestorm/diagnostic"
 	"fire/firestorm/parser"
+	"strconv"
 
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/types"
-	"github.com/llir/llvm/ir/value"
 )
 
 type CompiledFunction struct {
-	variables       map[string]*ir.InstAlloca
+	// local variables of the enclosing code blocks, innermost last
+	scopes []map[string]*ir.InstAlloca
+	// number of variables declared per name, used to keep the names of the allocas unique
+	declared        map[string]int
 	entryBlock      *ir.Block
 	returnBlock     *ir.Block
 	returnIncomings []*ir.Incoming
 	returnType      types.Type
 	name            string
 	endId           int
-	endExec         []*parser.Node
+	endExec         []endExec
+	loops           []loopTarget
 }
 
-func (cf *CompiledFunction) findVariable(name string, err func(string, *CompiledFunction)) (value.Value, types.Type) {
-	if v, ok := cf.variables[name]; ok {
-		return v, v.ElemType
+// loopTarget holds the blocks break and continue jump to for an enclosing loop.
+type loopTarget struct {
+	label         string
+	continueBlock *ir.Block
+	breakBlock    *ir.Block
+}
+
+// endExec is an end block together with the variables visible where it was declared.
+type endExec struct {
+	hit    *ir.InstAlloca
+	body   []*parser.Node
+	scopes []map[string]*ir.InstAlloca
+}
+
+func (cf *CompiledFunction) pushScope() {
+	cf.scopes = append(cf.scopes, make(map[string]*ir.InstAlloca))
+}
+
+func (cf *CompiledFunction) popScope() {
+	cf.scopes = cf.scopes[:len(cf.scopes)-1]
+}
+
+// declare creates the alloca of a local variable in the entry block, so loops don't grow the stack.
+func (cf *CompiledFunction) declare(name string, prefix string, t types.Type) *ir.InstAlloca {
+	v := cf.entryBlock.NewAlloca(t)
+	if n := cf.declared[name]; n > 0 {
+		v.SetName(prefix + name + "." + strconv.Itoa(n))
+	} else {
+		v.SetName(prefix + name)
 	}
-	err("Variable "+name+" not found!", cf)
+	cf.declared[name]++
+	cf.scopes[len(cf.scopes)-1][name] = v
+	return v
+}
+
+func (cf *CompiledFunction) findLoop(label string, err func(string, *CompiledFunction, ...diagnostic.Diagnostic)) loopTarget {
+	for i := len(cf.loops) - 1; i >= 0; i-- {
+		if label == "" || cf.loops[i].label == label {
+			return cf.loops[i]
+		}
+	}
+	if label == "" {
+		err("Not inside a loop", cf)
+	}
+	err("Loop "+label+" not found!", cf)
 	panic("?")
 }
+
+// findLocal looks up a local variable starting at the innermost scope.
+func (cf *CompiledFunction) findLocal(name string) (*ir.InstAlloca, bool) {
+	for i := len(cf.scopes) - 1; i >= 0; i-- {
+		if v, ok := cf.scopes[i][name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,50 @@
+package firestorm
+
+import (
+	"fire/firestorm/parser"
+	"fire/firestorm/target/llvm"
+	"sort"
+	"strings"
+)
+
+// units splits the declarations of the program into compilation units, one for every source file and one for
+// every used package. Units are ordered by their first declaration.
+func (l *loader) units(global *parser.Node) []llvm.Unit {
+	code := l.code.String()
+
+	// offsets at which the file changes and the file starting there
+	starts := []int{0}
+	files := []string{l.modules[0].path}
+	stack := []string{l.modules[0].path}
+	offset := 0
+	for _, line := range strings.SplitAfter(code, "\n") {
+		offset += len(line)
+		if file, ok := strings.CutPrefix(strings.TrimSuffix(line, "\n"), "//@file "); ok {
+			stack = append(stack, file)
+		} else if strings.HasPrefix(line, "//@endfile") && len(stack) > 1 {
+			stack = stack[:len(stack)-1]
+		} else {
+			continue
+		}
+		starts = append(starts, offset)
+		files = append(files, stack[len(stack)-1])
+	}
+
+	units := []llvm.Unit{}
+	index := map[string]int{}
+	for _, node := range global.Value.([]*parser.Node) {
+		i := sort.Search(len(starts), func(i int) bool { return starts[i] > node.Pos }) - 1
+		name := files[max(i, 0)]
+		if p := l.preprocessor.packageOf(name); p != "" {
+			name = p
+		}
+
+		if _, ok := index[name]; !ok {
+			index[name] = len(units)
+			units = append(units, llvm.Unit{Name: name})
+		}
+		unit := &units[index[name]]
+		unit.Nodes = append(unit.Nodes, node)
+	}
+	return units
+}
@@ -19,6 +19,8 @@ var AvailableCommands = map[string]command.Command{
 	"get":        commands.Get{},
 	"executable": commands.Executable{},
 	"compile":    commands.Compile{},
+	"doc":        commands.Doc{},
+	"clean":      commands.Clean{},
 }
 
 func main() {
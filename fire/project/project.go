@@ -10,6 +10,8 @@ type Compiler struct {
 	Target   *string  `json:"target"`
 	Input    string   `json:"input"`
 	Output   string   `json:"output"`
+	// Optimization is the level used unless fire build is given --opt or --profile
+	Optimization *string `json:"optimization"`
 }
 
 type Project struct {